@@ -1,7 +1,21 @@
+import datetime
+
 from client.rpc.types import Type, MessageType
 from client.rpc.exception import ApplicationException
 
 
+def from_millis(ms):
+    "Convert milliseconds since the unix epoch to an aware UTC datetime"
+    return datetime.datetime.fromtimestamp(ms / 1000.0, tz=datetime.timezone.utc)
+
+
+def to_millis(dt):
+    "Convert a datetime to milliseconds since the unix epoch, naive datetimes are taken as UTC"
+    if dt.tzinfo is None:
+        dt = dt.replace(tzinfo=datetime.timezone.utc)
+    return int(dt.timestamp() * 1000)
+
+
 class Flight(object):
     def __init__(self):
         self.id = ""
@@ -10,6 +24,8 @@ class Flight(object):
         self.time = ""
         self.available_seats = 0
         self.fare = 0.0
+        self.departure_time = None
+        self.arrival_time = None
 
     def read(self, iprot):
         while True:
@@ -28,6 +44,10 @@ class Flight(object):
                 self.available_seats = iprot.read_i32()
             elif fid == 6 and ftype == Type.FLOAT:
                 self.fare = iprot.read_float()
+            elif fid == 7 and ftype == Type.I64:
+                self.departure_time = from_millis(iprot.read_i64())
+            elif fid == 8 and ftype == Type.I64:
+                self.arrival_time = from_millis(iprot.read_i64())
             iprot.read_field_end()
 
 
@@ -75,10 +95,40 @@ class ReserveArgs(object):
         oprot.write_field_stop()
 
 
+class ReserveResult(object):
+    def __init__(self):
+        self.booking_ref = None
+
+    def read(self, iprot):
+        while True:
+            _, ftype, fid = iprot.read_field_begin()
+            if ftype == Type.STOP:
+                break
+            if fid == 1 and ftype == Type.STRING:
+                self.booking_ref = iprot.read_string()
+            iprot.read_field_end()
+
+
+class CancelReservationArgs(object):
+    def __init__(self):
+        self.booking_ref = None
+
+    def write(self, oprot):
+        if self.booking_ref is not None:
+            oprot.write_field_begin("bookingRef", Type.STRING, 1)
+            oprot.write_string(self.booking_ref)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
 class MonitorSeatsArgs(object):
     def __init__(self):
         self.flightid = None
         self.duration_ms = None
+        self.reliable = None
+        self.flightids = None
+        self.from_ = None
+        self.to = None
 
     def write(self, oprot):
         if self.flightid is not None:
@@ -89,12 +139,39 @@ class MonitorSeatsArgs(object):
             oprot.write_field_begin("durationMs", Type.I32, 2)
             oprot.write_i32(self.duration_ms)
             oprot.write_field_end()
+        if self.reliable is not None:
+            oprot.write_field_begin("reliable", Type.BOOL, 3)
+            oprot.write_bool(self.reliable)
+            oprot.write_field_end()
+        if self.flightids is not None:
+            oprot.write_field_begin("flightIDs", Type.LIST, 4)
+            oprot.write_list_begin(Type.STRING, len(self.flightids))
+            for flightid in self.flightids:
+                oprot.write_string(flightid)
+            oprot.write_list_end()
+            oprot.write_field_end()
+        if self.from_ is not None:
+            oprot.write_field_begin("from", Type.STRING, 5)
+            oprot.write_string(self.from_)
+            oprot.write_field_end()
+        if self.to is not None:
+            oprot.write_field_begin("to", Type.STRING, 6)
+            oprot.write_string(self.to)
+            oprot.write_field_end()
         oprot.write_field_stop()
 
 
 class MonitorSeatsResult(object):
     def __init__(self):
         self.seats = None
+        self.subscription_id = None
+        self.expires_in_ms = None
+        self.sequence = None
+        self.event = None
+        self.flightid = None
+        self.fare = None
+        self.departure_time = None
+        self.arrival_time = None
 
     def read(self, iprot):
         while True:
@@ -103,6 +180,132 @@ class MonitorSeatsResult(object):
                 break
             if fid == 1 and ftype == Type.I32:
                 self.seats = iprot.read_i32()
+            elif fid == 2 and ftype == Type.STRING:
+                self.subscription_id = iprot.read_string()
+            elif fid == 3 and ftype == Type.I32:
+                self.expires_in_ms = iprot.read_i32()
+            elif fid == 4 and ftype == Type.I32:
+                self.sequence = iprot.read_i32()
+            elif fid == 5 and ftype == Type.STRING:
+                self.event = iprot.read_string()
+            elif fid == 6 and ftype == Type.STRING:
+                self.flightid = iprot.read_string()
+            elif fid == 7 and ftype == Type.FLOAT:
+                self.fare = iprot.read_float()
+            elif fid == 8 and ftype == Type.I64:
+                self.departure_time = from_millis(iprot.read_i64())
+            elif fid == 9 and ftype == Type.I64:
+                self.arrival_time = from_millis(iprot.read_i64())
+            iprot.read_field_end()
+
+
+class CancelMonitorArgs(object):
+    def __init__(self):
+        self.subscription_id = None
+
+    def write(self, oprot):
+        if self.subscription_id is not None:
+            oprot.write_field_begin("subscriptionID", Type.STRING, 1)
+            oprot.write_string(self.subscription_id)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
+class AckMonitorArgs(object):
+    def __init__(self):
+        self.subscription_id = None
+        self.sequence = None
+
+    def write(self, oprot):
+        if self.subscription_id is not None:
+            oprot.write_field_begin("subscriptionID", Type.STRING, 1)
+            oprot.write_string(self.subscription_id)
+            oprot.write_field_end()
+        if self.sequence is not None:
+            oprot.write_field_begin("sequence", Type.I32, 2)
+            oprot.write_i32(self.sequence)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
+class RenewMonitorArgs(object):
+    def __init__(self):
+        self.subscription_id = None
+        self.duration_ms = None
+
+    def write(self, oprot):
+        if self.subscription_id is not None:
+            oprot.write_field_begin("subscriptionID", Type.STRING, 1)
+            oprot.write_string(self.subscription_id)
+            oprot.write_field_end()
+        if self.duration_ms is not None:
+            oprot.write_field_begin("durationMs", Type.I32, 2)
+            oprot.write_i32(self.duration_ms)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
+class RenewMonitorResult(object):
+    def __init__(self):
+        self.expires_at = None
+
+    def read(self, iprot):
+        while True:
+            _, ftype, fid = iprot.read_field_begin()
+            if ftype == Type.STOP:
+                break
+            if fid == 1 and ftype == Type.I64:
+                self.expires_at = from_millis(iprot.read_i64())
+            iprot.read_field_end()
+
+
+class Monitor(object):
+    def __init__(self):
+        self.subscription_id = None
+        self.flightids = None
+        self.expires_at = None
+        self.from_ = None
+        self.to = None
+
+    def read(self, iprot):
+        while True:
+            _, ftype, fid = iprot.read_field_begin()
+            if ftype == Type.STOP:
+                break
+            if fid == 1 and ftype == Type.STRING:
+                self.subscription_id = iprot.read_string()
+            elif fid == 2 and ftype == Type.LIST:
+                self.flightids = []
+                _, size = iprot.read_list_begin()
+                for _ in range(size):
+                    self.flightids.append(iprot.read_string())
+                iprot.read_list_end()
+            elif fid == 3 and ftype == Type.I64:
+                self.expires_at = from_millis(iprot.read_i64())
+            elif fid == 4 and ftype == Type.STRING:
+                self.from_ = iprot.read_string()
+            elif fid == 5 and ftype == Type.STRING:
+                self.to = iprot.read_string()
+            iprot.read_field_end()
+
+
+class ListMonitorsResult(object):
+    def __init__(self):
+        self.monitors = None
+
+    def read(self, iprot):
+        while True:
+            _, ftype, fid = iprot.read_field_begin()
+            if ftype == Type.STOP:
+                break
+            if fid == 1 and ftype == Type.LIST:
+                self.monitors = []
+                _, size = iprot.read_list_begin()
+                for _ in range(size):
+                    monitor = Monitor()
+                    monitor.read(iprot)
+                    self.monitors.append(monitor)
+                iprot.read_list_end()
             iprot.read_field_end()
 
 
@@ -143,6 +346,39 @@ class NewFlightArgs(object):
         oprot.write_field_stop()
 
 
+class UpdateFlightArgs(object):
+    def __init__(self):
+        self.flightid = None
+        self.departure_time = None
+        self.arrival_time = None
+        self.available_seats = None
+        self.fare = None
+
+    def write(self, oprot):
+        if self.flightid is not None:
+            oprot.write_field_begin("id", Type.STRING, 1)
+            oprot.write_string(self.flightid)
+            oprot.write_field_end()
+        if self.departure_time is not None:
+            oprot.write_field_begin("departureTime", Type.I64, 2)
+            oprot.write_i64(to_millis(self.departure_time))
+            oprot.write_field_end()
+        if self.arrival_time is not None:
+            # 0 makes the arrival time unknown
+            oprot.write_field_begin("arrivalTime", Type.I64, 3)
+            oprot.write_i64(to_millis(self.arrival_time) if self.arrival_time else 0)
+            oprot.write_field_end()
+        if self.available_seats is not None:
+            oprot.write_field_begin("availableSeats", Type.I32, 4)
+            oprot.write_i32(self.available_seats)
+            oprot.write_field_end()
+        if self.fare is not None:
+            oprot.write_field_begin("fare", Type.FLOAT, 5)
+            oprot.write_float(self.fare)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
 class FindFlightsArgs(object):
     def __init__(self):
         self.from_ = None
@@ -177,6 +413,83 @@ class FindFlightsResult(object):
                 iprot.read_list_end()
             iprot.read_field_end()
 
+class SearchFlightsArgs(object):
+    def __init__(self):
+        self.from_ = None
+        self.to = None
+        self.depart_after = None
+        self.depart_before = None
+        self.max_fare = None
+        self.min_seats = None
+        self.sort_by = None
+        self.descending = None
+        self.limit = None
+        self.offset = None
+
+    def write(self, oprot):
+        if self.from_ is not None:
+            oprot.write_field_begin("from", Type.STRING, 1)
+            oprot.write_string(self.from_)
+            oprot.write_field_end()
+        if self.to is not None:
+            oprot.write_field_begin("to", Type.STRING, 2)
+            oprot.write_string(self.to)
+            oprot.write_field_end()
+        if self.depart_after is not None:
+            oprot.write_field_begin("departAfter", Type.I64, 3)
+            oprot.write_i64(to_millis(self.depart_after))
+            oprot.write_field_end()
+        if self.depart_before is not None:
+            oprot.write_field_begin("departBefore", Type.I64, 4)
+            oprot.write_i64(to_millis(self.depart_before))
+            oprot.write_field_end()
+        if self.max_fare is not None:
+            oprot.write_field_begin("maxFare", Type.FLOAT, 5)
+            oprot.write_float(self.max_fare)
+            oprot.write_field_end()
+        if self.min_seats is not None:
+            oprot.write_field_begin("minSeats", Type.I32, 6)
+            oprot.write_i32(self.min_seats)
+            oprot.write_field_end()
+        if self.sort_by is not None:
+            oprot.write_field_begin("sortBy", Type.STRING, 7)
+            oprot.write_string(self.sort_by)
+            oprot.write_field_end()
+        if self.descending is not None:
+            oprot.write_field_begin("descending", Type.BOOL, 8)
+            oprot.write_bool(self.descending)
+            oprot.write_field_end()
+        if self.limit is not None:
+            oprot.write_field_begin("limit", Type.I32, 9)
+            oprot.write_i32(self.limit)
+            oprot.write_field_end()
+        if self.offset is not None:
+            oprot.write_field_begin("offset", Type.I32, 10)
+            oprot.write_i32(self.offset)
+            oprot.write_field_end()
+        oprot.write_field_stop()
+
+
+class SearchFlightsResult(object):
+    def __init__(self):
+        self.flights = None
+
+    def read(self, iprot):
+        while True:
+            _, ftype, fid = iprot.read_field_begin()
+            if ftype == Type.STOP:
+                break
+            if fid == 1 and ftype == Type.LIST:
+                self.flights = []
+                _, size = iprot.read_list_begin()
+                for _ in range(size):
+                    flight = Flight()
+                    flight.read(iprot)
+                    self.flights.append(flight)
+                iprot.read_list_end()
+            iprot.read_field_end()
+
+
 class FindDestinationsArgs(object):
     def __init__(self):
         self.from_ = None
@@ -248,7 +561,7 @@ class Client(object):
 
     def reserve(self, flightid, seats):
         self.send_reserve(flightid, seats)
-        self.recv_reserve()
+        return self.recv_reserve()
 
     def send_reserve(self, flightid, seats):
         flightid = str(flightid)
@@ -263,6 +576,36 @@ class Client(object):
         self.oprot.trans.flush()
 
     def recv_reserve(self):
+        _, mtype, _ = self.iprot.read_message_begin()
+        if mtype == MessageType.EXCEPTION:
+            e = ApplicationException()
+            e.read(self.iprot)
+            self.iprot.read_message_end()
+            raise e
+
+        result = ReserveResult()
+        result.read(self.iprot)
+        self.iprot.read_message_end()
+
+        return result.booking_ref
+
+    def cancel_reservation(self, booking_ref):
+        self.send_cancel_reservation(booking_ref)
+        self.recv_cancel_reservation()
+
+    def send_cancel_reservation(self, booking_ref):
+        booking_ref = str(booking_ref)
+
+        self.oprot.write_message_begin(
+            "cancelReservation", MessageType.CALL, self.seqid
+        )
+        args = CancelReservationArgs()
+        args.booking_ref = booking_ref
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+    def recv_cancel_reservation(self):
         _, mtype, _ = self.iprot.read_message_begin()
         if mtype == MessageType.EXCEPTION:
             e = ApplicationException()
@@ -278,14 +621,21 @@ class Client(object):
         self.send_reserve(flightid, duration_ms)
         self.recv_reserve()
 
-    def send_monitor_seats(self, flightid, duration_ms):
-        flightid = str(flightid)
-        duration_ms = int(duration_ms)
+    def send_monitor_seats(self, flightid, duration_ms, reliable=True):
+        self.send_monitor_flights(duration_ms, [flightid], reliable=reliable)
 
+    def send_monitor_flights(
+        self, duration_ms, flightids=None, from_=None, to=None, reliable=True
+    ):
+        "Monitor the listed flights and the flights of a route in one subscription"
         self.oprot.write_message_begin("monitorSeats", MessageType.CALL, self.seqid)
         args = MonitorSeatsArgs()
-        args.flightid = flightid
-        args.duration_ms = duration_ms
+        args.duration_ms = int(duration_ms)
+        args.reliable = reliable
+        if flightids:
+            args.flightids = [str(flightid) for flightid in flightids]
+        args.from_ = from_
+        args.to = to
         args.write(self.oprot)
         self.oprot.write_message_end()
         self.oprot.trans.flush()
@@ -307,7 +657,73 @@ class Client(object):
 
         self.iprot.trans.listen = False
 
-        return result.seats
+        return result
+
+    def ack_monitor(self, subscription_id, sequence):
+        "Acknowledge the monitor update with sequence, the server sends no reply"
+        self.oprot.write_message_begin("ackMonitor", MessageType.ONEWAY, self.seqid)
+        args = AckMonitorArgs()
+        args.subscription_id = subscription_id
+        args.sequence = sequence
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+    def cancel_monitor(self, subscription_id):
+        self.oprot.write_message_begin("cancelMonitor", MessageType.CALL, self.seqid)
+        args = CancelMonitorArgs()
+        args.subscription_id = str(subscription_id)
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+        _, mtype, _ = self.iprot.read_message_begin()
+        if mtype == MessageType.EXCEPTION:
+            e = ApplicationException()
+            e.read(self.iprot)
+            self.iprot.read_message_end()
+            raise e
+        self.iprot.read_field_begin()  # for reading STOP
+        self.iprot.read_message_end()
+
+    def renew_monitor(self, subscription_id, duration_ms):
+        self.oprot.write_message_begin("renewMonitor", MessageType.CALL, self.seqid)
+        args = RenewMonitorArgs()
+        args.subscription_id = str(subscription_id)
+        args.duration_ms = int(duration_ms)
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+        _, mtype, _ = self.iprot.read_message_begin()
+        if mtype == MessageType.EXCEPTION:
+            e = ApplicationException()
+            e.read(self.iprot)
+            self.iprot.read_message_end()
+            raise e
+        result = RenewMonitorResult()
+        result.read(self.iprot)
+        self.iprot.read_message_end()
+
+        return result.expires_at
+
+    def list_monitors(self):
+        self.oprot.write_message_begin("listMonitors", MessageType.CALL, self.seqid)
+        self.oprot.write_field_stop()
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+        _, mtype, _ = self.iprot.read_message_begin()
+        if mtype == MessageType.EXCEPTION:
+            e = ApplicationException()
+            e.read(self.iprot)
+            self.iprot.read_message_end()
+            raise e
+        result = ListMonitorsResult()
+        result.read(self.iprot)
+        self.iprot.read_message_end()
+
+        return result.monitors
 
     def new_flight(self, flightid, from_, to, time, available_seats, fare):
         self.send_new_flight(flightid, from_, to, time, available_seats, fare)
@@ -343,6 +759,35 @@ class Client(object):
         self.iprot.read_field_begin()  # for reading STOP
         self.iprot.read_message_end()
 
+    def update_flight(
+        self, flightid, departure_time=None, arrival_time=None, available_seats=None,
+        fare=None,
+    ):
+        """Change the given fields of a flight and return the updated flight, an
+        arrival_time of False makes the arrival time unknown"""
+        self.oprot.write_message_begin("updateFlight", MessageType.CALL, self.seqid)
+        args = UpdateFlightArgs()
+        args.flightid = str(flightid)
+        args.departure_time = departure_time
+        args.arrival_time = arrival_time
+        if available_seats is not None:
+            args.available_seats = int(available_seats)
+        if fare is not None:
+            args.fare = float(fare)
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+        return self.recv_get_flight()
+
+    def delete_flight(self, flightid):
+        self.oprot.write_message_begin("deleteFlight", MessageType.CALL, self.seqid)
+        args = GetFlightArgs()
+        args.flightid = str(flightid)
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+        self.recv_new_flight()
+
     def find_flights(self, from_, to):
         self.send_find_flights(from_, to)
         return self.recv_find_flights()
@@ -373,6 +818,53 @@ class Client(object):
 
         return result.flight_ids
 
+    def search_flights(self, from_=None, to=None, **filters):
+        self.send_search_flights(from_, to, **filters)
+        return self.recv_search_flights()
+
+    def send_search_flights(
+        self,
+        from_=None,
+        to=None,
+        depart_after=None,
+        depart_before=None,
+        max_fare=None,
+        min_seats=None,
+        sort_by=None,
+        descending=None,
+        limit=None,
+        offset=None,
+    ):
+        self.oprot.write_message_begin("searchFlights", MessageType.CALL, self.seqid)
+        args = SearchFlightsArgs()
+        args.from_ = from_
+        args.to = to
+        args.depart_after = depart_after
+        args.depart_before = depart_before
+        args.max_fare = None if max_fare is None else float(max_fare)
+        args.min_seats = None if min_seats is None else int(min_seats)
+        args.sort_by = sort_by
+        args.descending = descending
+        args.limit = None if limit is None else int(limit)
+        args.offset = None if offset is None else int(offset)
+        args.write(self.oprot)
+        self.oprot.write_message_end()
+        self.oprot.trans.flush()
+
+    def recv_search_flights(self):
+        _, mtype, _ = self.iprot.read_message_begin()
+        if mtype == MessageType.EXCEPTION:
+            e = ApplicationException()
+            e.read(self.iprot)
+            self.iprot.read_message_end()
+            raise e
+
+        result = SearchFlightsResult()
+        result.read(self.iprot)
+        self.iprot.read_message_end()
+
+        return result.flights
+
     def find_destinations(self, from_):
         self.send_find_destinations(from_)
         return self.recv_find_destinations()
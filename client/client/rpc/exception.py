@@ -1,10 +1,30 @@
+from client.rpc.protocol import skip
 from client.rpc.types import Type
 
 class ApplicationException(Exception):
-    def __init__(self, message=""):
+    UNKNOWN = 0
+    UNKNOWN_METHOD = 1
+    INTERNAL_ERROR = 6
+    PROTOCOL_ERROR = 7
+    UNAUTHENTICATED = 11
+    PERMISSION_DENIED = 12
+
+    # codes of the flight service
+    FLIGHT_NOT_FOUND = 100
+    INSUFFICIENT_SEATS = 101
+    DUPLICATE_FLIGHT = 102
+    INVALID_ARGUMENT = 103
+    RESERVATION_NOT_FOUND = 104
+    FLIGHT_RESERVED = 105
+    MONITOR_NOT_FOUND = 106
+    TOO_MANY_MONITORS = 107
+    MONITOR_CLOSED = 108
+
+    def __init__(self, message="", type=UNKNOWN):
         super().__init__(message)
 
         self.message = message
+        self.type = type
 
     def __str__(self):
         return self.message
@@ -16,4 +36,8 @@ class ApplicationException(Exception):
                 break
             if fid == 1 and ftype == Type.STRING:
                 self.message = iprot.read_string()
+            elif fid == 2 and ftype == Type.I32:
+                self.type = iprot.read_i32()
+            else:
+                skip(iprot, ftype)
             iprot.read_field_end()
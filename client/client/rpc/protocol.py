@@ -41,6 +41,9 @@ class ProtocolBase(object):
     def write_i32(self, value):
         raise NotImplementedError
 
+    def write_i64(self, value):
+        raise NotImplementedError
+
     def write_float(self, value):
         raise NotImplementedError
 
@@ -74,6 +77,9 @@ class ProtocolBase(object):
     def raise_i32(self):
         raise NotImplementedError
 
+    def read_i64(self):
+        raise NotImplementedError
+
     def read_float(self):
         raise NotImplementedError
 
@@ -134,6 +140,10 @@ class BinaryProtocol(ProtocolBase):
         buf = struct.pack("!i", value)
         self.trans.write(buf)
 
+    def write_i64(self, value):
+        buf = struct.pack("!q", value)
+        self.trans.write(buf)
+
     def write_float(self, value):
         buf = struct.pack("!f", value)
         self.trans.write(buf)
@@ -193,6 +203,11 @@ class BinaryProtocol(ProtocolBase):
         val, = struct.unpack("!i", buf)
         return val
 
+    def read_i64(self):
+        buf = self.trans.read_all(8)
+        val, = struct.unpack("!q", buf)
+        return val
+
     def read_float(self):
         buf = self.trans.read_all(4)
         val, = struct.unpack("!f", buf)
@@ -205,3 +220,33 @@ class BinaryProtocol(ProtocolBase):
         size = self.read_i32()
         s = self.trans.read_all(size)
         return s
+
+
+def skip(iprot, ftype):
+    "Read and discard a value of type ftype, e.g. a field the reader doesn't know"
+    if ftype == Type.BOOL:
+        iprot.read_bool()
+    elif ftype == Type.BYTE:
+        iprot.read_byte()
+    elif ftype == Type.FLOAT:
+        iprot.read_float()
+    elif ftype == Type.I16:
+        iprot.read_i16()
+    elif ftype == Type.I32:
+        iprot.read_i32()
+    elif ftype == Type.I64:
+        iprot.read_i64()
+    elif ftype == Type.STRING:
+        iprot.read_binary()
+    elif ftype == Type.STRUCT:
+        while True:
+            _, field_type, _ = iprot.read_field_begin()
+            if field_type == Type.STOP:
+                break
+            skip(iprot, field_type)
+            iprot.read_field_end()
+    elif ftype == Type.LIST:
+        etype, size = iprot.read_list_begin()
+        for _ in range(size):
+            skip(iprot, etype)
+        iprot.read_list_end()
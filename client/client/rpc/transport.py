@@ -1,10 +1,75 @@
+import hashlib
+import hmac
 import socket
+import struct
+import time
+import uuid
 
 import random
 
 from client.config import Config
 
 
+# Marks a datagram starting with a request header, see rpc/header.go on the server
+REQUEST_HEADER_MAGIC = 0x80A70001
+
+# Marks a datagram starting with a request header signed with an API key, see
+# WriteSignedRequestHeader in rpc/header.go on the server
+SIGNED_REQUEST_HEADER_MAGIC = 0x80A70003
+
+# Marks a fragment of a message longer than a datagram, see rpc/fragment.go on
+# the server
+FRAGMENT_MAGIC = 0x80A70002
+FRAGMENT_DATA = 1
+FRAGMENT_NACK = 2
+FRAGMENT_HEADER_SIZE = 17
+
+
+def request_header(client_id, request_id):
+    "Encode the header identifying a request for at-most-once processing"
+    client_id = bytes(client_id, "utf8")
+    return (
+        struct.pack("!Ii", REQUEST_HEADER_MAGIC, len(client_id))
+        + client_id
+        + struct.pack("!q", request_id)
+    )
+
+
+def signed_request_header(client_id, request_id, key_id, secret, body):
+    "Encode the header of a request signed with HMAC-SHA256 over header and body"
+    client_id = bytes(client_id, "utf8")
+    key_id = bytes(key_id, "utf8")
+    header = (
+        struct.pack("!Ii", SIGNED_REQUEST_HEADER_MAGIC, len(client_id))
+        + client_id
+        + struct.pack("!qi", request_id, len(key_id))
+        + key_id
+        + struct.pack("!q", int(time.time() * 1000))
+    )
+    return header + hmac.new(secret, header + body, hashlib.sha256).digest()
+
+
+def split_message(message_id, msg, max_size):
+    "Split a message longer than max_size into fragment datagrams"
+    if len(msg) <= max_size:
+        return [msg]
+    payload_size = max_size - FRAGMENT_HEADER_SIZE
+    total = (len(msg) + payload_size - 1) // payload_size
+    return [
+        struct.pack("!IBQHH", FRAGMENT_MAGIC, FRAGMENT_DATA, message_id, i, total)
+        + msg[i * payload_size : (i + 1) * payload_size]
+        for i in range(total)
+    ]
+
+
+def nack_datagram(message_id, missing):
+    "Encode a request to retransmit the missing fragments of a message"
+    return struct.pack(
+        "!IBQH%dH" % len(missing), FRAGMENT_MAGIC, FRAGMENT_NACK, message_id,
+        len(missing), *missing
+    )
+
+
 class TransportBase(object):
     def is_open(self):
         raise NotImplementedError
@@ -51,7 +116,17 @@ class UDPSocket(TransportBase):
         self._readbuf = None
         self._readbuf_offset = 0
         self._writebuf = None
-        self._prev_writebuf = None
+        self._prev_datagrams = None
+
+        # fragments of the reply being reassembled, by index
+        self._fragments = None
+        self._fragments_id = None
+        self._message_id = random.getrandbits(63)
+
+        self.client_id = uuid.uuid4().hex
+        self._request_id = 0
+        self._key_id = None
+        self._secret = None
 
         self.listen = False
 
@@ -81,6 +156,11 @@ class UDPSocket(TransportBase):
         if self.handle is not None:
             self.handle.settimeout(self._timeout)
 
+    def set_credentials(self, key_id, secret):
+        "Sign requests with the API key of key_id and the secret bytes"
+        self._key_id = key_id
+        self._secret = secret
+
     def set_num_retries(self, num_retries):
         self._num_retries = num_retries
 
@@ -113,18 +193,59 @@ class UDPSocket(TransportBase):
                     buf, addr = self.handle.recvfrom(Config.UDP_BUF_SIZE)
             except socket.timeout:
                 if trial < self._num_retries:
-                    print(f"receive timed out, retrying...")
-                    self._writebuf = self._prev_writebuf
-                    self.flush()
+                    if self._fragments is not None:
+                        print(f"receive timed out, asking for missing fragments...")
+                        self._send_missing_nack()
+                    else:
+                        print(f"receive timed out, retrying...")
+                        # resend the same datagrams so the server recognizes the retry
+                        for datagram in self._prev_datagrams:
+                            self._send(datagram)
                 trial += 1
             else:
                 if addr == (self.host, self.port):
+                    if self._is_fragment(buf):
+                        buf = self._handle_fragment(buf)
+                        if buf is None:
+                            continue
                     self._readbuf = buf
                     return
         if self.listen:
             self.handle.settimeout(self._timeout)
         raise Exception("aborting, cannot receive from server")
 
+    def _is_fragment(self, buf):
+        return len(buf) >= 4 and struct.unpack("!I", buf[:4])[0] == FRAGMENT_MAGIC
+
+    def _handle_fragment(self, buf):
+        "Store a reply fragment, returning the reply once it is complete"
+        kind, message_id = struct.unpack("!BQ", buf[4:13])
+        if kind == FRAGMENT_NACK:
+            if message_id != self._message_id or len(self._prev_datagrams) == 1:
+                return None
+            (count,) = struct.unpack("!H", buf[13:15])
+            for index in struct.unpack("!%dH" % count, buf[15 : 15 + 2 * count]):
+                if index < len(self._prev_datagrams):
+                    self._send(self._prev_datagrams[index])
+            return None
+
+        index, total = struct.unpack("!HH", buf[13:FRAGMENT_HEADER_SIZE])
+        if self._fragments_id != message_id:
+            self._fragments_id = message_id
+            self._fragments = [None] * total
+        if index < len(self._fragments):
+            self._fragments[index] = buf[FRAGMENT_HEADER_SIZE:]
+        if any(f is None for f in self._fragments):
+            return None
+        reply = b"".join(self._fragments)
+        self._fragments = None
+        self._fragments_id = None
+        return reply
+
+    def _send_missing_nack(self):
+        missing = [i for i, f in enumerate(self._fragments) if f is None]
+        self._send(nack_datagram(self._fragments_id, missing))
+
     def read(self, size):
         if self._readbuf is None:
             self._read_server()
@@ -141,18 +262,35 @@ class UDPSocket(TransportBase):
         if not self.handle:
             raise Exception("Socket not open")
 
+        self._request_id += 1
+        body = bytes(self._writebuf)
+        if self._key_id is not None:
+            header = signed_request_header(
+                self.client_id, self._request_id, self._key_id, self._secret, body
+            )
+        else:
+            header = request_header(self.client_id, self._request_id)
+        datagram = header + body
+        self._message_id += 1
+        datagrams = split_message(self._message_id, datagram, self.max_buf_size)
+        for d in datagrams:
+            self._send(d)
+
+        self._prev_datagrams = datagrams
+        self.clear_bufs()
+
+    def _send(self, datagram):
         if (
             self._outgoing_drop_rate > 0.0
             and random.random() < self._outgoing_drop_rate
         ):
             print("\U000026D4 Outgoing packet is dropped \U000026D4")
         else:
-            self.handle.sendto(bytes(self._writebuf), (self.host, self.port))
-
-        self._prev_writebuf = self._writebuf
-        self.clear_bufs()
+            self.handle.sendto(datagram, (self.host, self.port))
 
     def clear_bufs(self):
         self._writebuf = None
         self._readbuf = None
         self._readbuf_offset = 0
+        self._fragments = None
+        self._fragments_id = None
@@ -9,6 +9,7 @@ class Type(object):
     STRING = 7
     STRUCT = 8
     LIST = 9
+    I64 = 10
 
     _VALUES_TO_NAMES = (
         "STOP",
@@ -21,6 +22,7 @@ class Type(object):
         "STRING",
         "STRUCT",
         "LIST",
+        "I64",
     )
 
 
@@ -28,3 +30,4 @@ class MessageType(object):
     CALL = 1
     REPLY = 2
     EXCEPTION = 3
+    ONEWAY = 4
@@ -27,6 +27,8 @@ parser.add_argument(
 parser.add_argument(
     "--retry", "-r", type=int, help="Number of timeout retries before aborting"
 )
+parser.add_argument("--key-id", help="ID of the API key to sign requests with")
+parser.add_argument("--secret", help="Hex encoded secret of the API key")
 
 
 class FlightShell(cmd.Cmd):
@@ -44,7 +46,9 @@ class FlightShell(cmd.Cmd):
             print("flight id:", flight.id)
             print("from:", flight.from_)
             print("to:", flight.to)
-            print("time:", flight.time)
+            print("departure time:", flight.departure_time or flight.time)
+            if flight.arrival_time is not None:
+                print("arrival time:", flight.arrival_time)
             print("num available seats:", flight.available_seats)
             print("ticket fare:", flight.fare)
         except ApplicationException as e:
@@ -53,7 +57,15 @@ class FlightShell(cmd.Cmd):
     def do_reserve(self, arg):
         "reserve flight by id and seats: ID SEATS"
         try:
-            self.client.reserve(*parse(arg))
+            booking_ref = self.client.reserve(*parse(arg))
+            print("booking reference:", booking_ref)
+        except ApplicationException as e:
+            print(str(e))
+
+    def do_cancel(self, arg):
+        "cancel reservation by booking reference: BOOKING_REF"
+        try:
+            self.client.cancel_reservation(*parse(arg))
             print("ok")
         except ApplicationException as e:
             print(str(e))
@@ -61,28 +73,144 @@ class FlightShell(cmd.Cmd):
     def do_monitor_seats(self, arg):
         "monitor available seats: ID DURATION_IN_MS"
         flightid, duration_ms = parse(arg)
-        duration_ms = int(duration_ms)
-
         self.client.send_monitor_seats(flightid, duration_ms)
+        self._monitor(int(duration_ms))
+
+    def do_monitor(self, arg):
+        "monitor flights and routes: DURATION_IN_MS [ids=ID,ID...] [from=FROM] [to=TO]"
+        duration_ms, *options = parse(arg)
+        query = dict(option.split("=", 1) for option in options)
+        flightids = query.get("ids")
+        self.client.send_monitor_flights(
+            duration_ms,
+            flightids.split(",") if flightids else None,
+            from_=query.get("from"),
+            to=query.get("to"),
+        )
+        self._monitor(int(duration_ms))
 
+    def _monitor(self, duration_ms):
         wait_until = datetime.datetime.now() + datetime.timedelta(
             milliseconds=duration_ms
         )
+        subscription_id = None
+        seen = set()
         while datetime.datetime.now() < wait_until:
             try:
-                seats = self.client.recv_monitor_seats()
-                print("available seats:", seats)
+                result = self.client.recv_monitor_seats()
+                if result.subscription_id != subscription_id:
+                    subscription_id = result.subscription_id
+                    print("subscription:", subscription_id)
+                if result.sequence is not None:
+                    self.client.ack_monitor(subscription_id, result.sequence)
+                    if result.sequence in seen:
+                        # retransmission of an update already shown
+                        continue
+                    seen.add(result.sequence)
+                if result.expires_in_ms is not None:
+                    # a renewal extends the monitoring
+                    wait_until = datetime.datetime.now() + datetime.timedelta(
+                        milliseconds=result.expires_in_ms
+                    )
+                if result.event == "renewed":
+                    print("renewed")
+                elif result.event == "fare":
+                    print(result.flightid, "fare:", result.fare)
+                elif result.event == "newFlight":
+                    print(result.flightid, "new flight, available seats:", result.seats)
+                elif result.event == "schedule":
+                    print(
+                        result.flightid,
+                        "rescheduled, departure:",
+                        result.departure_time,
+                        "arrival:",
+                        result.arrival_time,
+                    )
+                elif result.event == "removed":
+                    print(result.flightid, "removed")
+                else:
+                    print(result.flightid, "available seats:", result.seats)
             except ApplicationException as e:
                 print(str(e))
+                if e.type == ApplicationException.MONITOR_CLOSED:
+                    break
+
+    def do_cancel_monitor(self, arg):
+        "cancel monitor: SUBSCRIPTION_ID"
+        try:
+            self.client.cancel_monitor(*parse(arg))
+            print("ok")
+        except ApplicationException as e:
+            print(str(e))
+
+    def do_renew_monitor(self, arg):
+        "renew monitor: SUBSCRIPTION_ID DURATION_IN_MS"
+        try:
+            expires_at = self.client.renew_monitor(*parse(arg))
+            print("expires at:", expires_at)
+        except ApplicationException as e:
+            print(str(e))
+
+    def do_monitors(self, arg):
+        "list active monitors of this client"
+        try:
+            for monitor in self.client.list_monitors():
+                route = "%s-%s" % (monitor.from_ or "*", monitor.to or "*")
+                if not monitor.from_ and not monitor.to:
+                    route = "-"
+                print(
+                    monitor.subscription_id,
+                    ",".join(monitor.flightids or []) or "-",
+                    route,
+                    monitor.expires_at,
+                )
+        except ApplicationException as e:
+            print(str(e))
 
     def do_new(self, arg):
-        "create new flight entry: ID FROM TO TIME AVAILABLE-SEATS FARE"
+        "create new flight entry: ID FROM TO TIME(YYYY-MM-DDTHH:MM[+HH:MM]) AVAILABLE-SEATS FARE"
         try:
             self.client.new_flight(*parse(arg))
             print("ok")
         except ApplicationException as e:
             print(str(e))
 
+    def do_update(self, arg):
+        """update flight: ID [departure=TIME] [arrival=TIME|none] [seats=SEATS] [fare=FARE]"""
+        flightid, *tokens = parse(arg)
+        fields = {}
+        for token in tokens:
+            key, _, value = token.partition("=")
+            if key == "departure":
+                fields["departure_time"] = datetime.datetime.fromisoformat(value)
+            elif key == "arrival":
+                fields["arrival_time"] = (
+                    False if value == "none" else datetime.datetime.fromisoformat(value)
+                )
+            elif key == "seats":
+                fields["available_seats"] = value
+            else:
+                fields[key] = value
+        try:
+            flight = self.client.update_flight(flightid, **fields)
+            print(
+                flight.id,
+                flight.departure_time,
+                flight.arrival_time,
+                flight.available_seats,
+                flight.fare,
+            )
+        except (ApplicationException, TypeError, ValueError) as e:
+            print(str(e))
+
+    def do_delete(self, arg):
+        "delete flight without reservations: ID"
+        try:
+            self.client.delete_flight(*parse(arg))
+            print("ok")
+        except ApplicationException as e:
+            print(str(e))
+
     def do_find_flights(self, arg):
         "find flights: FROM TO"
         try:
@@ -92,6 +220,36 @@ class FlightShell(cmd.Cmd):
         except ApplicationException as e:
             print(str(e))
 
+    def do_search(self, arg):
+        """search flights: [from=FROM] [to=TO] [after=TIME] [before=TIME] [max_fare=FARE]
+        [min_seats=SEATS] [sort=departure|fare|seats] [desc] [limit=N] [offset=N]"""
+        filters = {}
+        for token in parse(arg):
+            key, _, value = token.partition("=")
+            if key == "from":
+                filters["from_"] = value
+            elif key in ("after", "before"):
+                filters["depart_" + key] = datetime.datetime.fromisoformat(value)
+            elif key == "sort":
+                filters["sort_by"] = value
+            elif key == "desc":
+                filters["descending"] = True
+            else:
+                filters[key] = value
+        try:
+            flights = self.client.search_flights(**filters)
+            for flight in flights:
+                print(
+                    flight.id,
+                    flight.from_,
+                    flight.to,
+                    flight.departure_time or flight.time,
+                    flight.available_seats,
+                    flight.fare,
+                )
+        except (ApplicationException, TypeError, ValueError) as e:
+            print(str(e))
+
     def do_find_destinations(self, arg):
         "find destinations: FROM"
         try:
@@ -119,6 +277,8 @@ if __name__ == "__main__":
         transport.set_incoming_drop(args.incoming_drop)
     if args.outgoing_drop is not None:
         transport.set_outgoing_drop(args.outgoing_drop)
+    if args.key_id is not None:
+        transport.set_credentials(args.key_id, bytes.fromhex(args.secret or ""))
     protocol = BinaryProtocol(transport)
     client = Client(protocol)
 
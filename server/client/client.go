@@ -0,0 +1,419 @@
+// Package client implements a client of the flight information RPC service
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/rpc"
+)
+
+const (
+	// DefaultTimeout is how long a call waits for a reply before retrying
+	DefaultTimeout = time.Second
+	// DefaultRetries is how many times a call is retried after timing out
+	DefaultRetries = 3
+)
+
+//go:generate go run ../cmd/rpcgen -mode client -o flight_rpc.go ../flight/flight.idl
+
+type argsWriter interface {
+	write(oprot rpc.Protocol) error
+}
+
+type resultReader interface {
+	read(iprot rpc.Protocol) error
+}
+
+// Client calls the methods of the flight service. Calls are made one at a time,
+// a Client is safe for concurrent use but concurrent calls are serialized.
+type Client struct {
+	// Timeout is how long each attempt of a call waits for the reply
+	Timeout time.Duration
+	// Retries is how many times a call is retried after timing out. Retries
+	// resend the same request, with the same sequence and request ID.
+	Retries int
+
+	mu        sync.Mutex
+	trans     rpc.ClientTransport
+	iprot     rpc.Protocol
+	clientID  string
+	seqID     int32
+	requestID int64
+
+	// requests are encoded in buf by oprot before being sent with their
+	// header, the header of a signed request covers the encoded message
+	buf   *rpc.MemoryBuffer
+	oprot rpc.Protocol
+
+	keyID  string
+	secret []byte
+}
+
+// New creates a client exchanging messages over trans, which must be open
+func New(trans rpc.ClientTransport, protocolFactory rpc.ProtocolFactory) *Client {
+	buf := rpc.NewMemoryBuffer()
+	return &Client{
+		Timeout:  DefaultTimeout,
+		Retries:  DefaultRetries,
+		trans:    trans,
+		iprot:    protocolFactory.GetProtocol(trans),
+		buf:      buf,
+		oprot:    protocolFactory.GetProtocol(buf),
+		clientID: newClientID(),
+	}
+}
+
+// NewWithTransportFactory creates a client exchanging messages over trans
+// wrapped by transportFactory, e.g. to encrypt them with
+// rpc.NewSecureTransportFactory. The wrapped transport must be a
+// ClientTransport.
+func NewWithTransportFactory(trans rpc.ClientTransport, transportFactory rpc.TransportFactory, protocolFactory rpc.ProtocolFactory) (*Client, error) {
+	wrapped, err := transportFactory.GetTransport(trans)
+	if err != nil {
+		return nil, err
+	}
+	clientTrans, ok := wrapped.(rpc.ClientTransport)
+	if !ok {
+		return nil, errors.New("transport factory doesn't give a client transport")
+	}
+	return New(clientTrans, protocolFactory), nil
+}
+
+// SetCredentials makes the client sign its requests with the API key of the
+// given ID and secret, which admin methods require on servers authenticating
+// requests
+func (c *Client) SetCredentials(keyID string, secret []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keyID = keyID
+	c.secret = secret
+}
+
+// Dial creates a client of the server at addr using UDP and the binary protocol
+func Dial(addr string) (*Client, error) {
+	trans, err := rpc.NewUDPClientSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := trans.Open(); err != nil {
+		return nil, err
+	}
+	return New(trans, rpc.NewBinaryProtocolFactory()), nil
+}
+
+// DialTCP creates a client of the server at addr using TCP and the binary
+// protocol
+func DialTCP(addr string) (*Client, error) {
+	trans, err := rpc.NewTCPClientSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := trans.Open(); err != nil {
+		return nil, err
+	}
+	return New(trans, rpc.NewBinaryProtocolFactory()), nil
+}
+
+// Close closes the underlying transport
+func (c *Client) Close() error {
+	return c.trans.Close()
+}
+
+func newClientID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// send writes a message calling method with its arguments, returning its
+// sequence ID
+func (c *Client) send(method string, typeID rpc.MessageType, args argsWriter) (int32, error) {
+	seqID := c.seqID
+	c.seqID++
+	c.requestID++
+
+	c.buf.Reset()
+	if err := c.oprot.WriteMessageBegin(method, typeID, seqID); err != nil {
+		return seqID, err
+	}
+	if err := args.write(c.oprot); err != nil {
+		return seqID, err
+	}
+	if err := c.oprot.WriteMessageEnd(); err != nil {
+		return seqID, err
+	}
+
+	header := &rpc.RequestHeader{ClientID: c.clientID, RequestID: c.requestID}
+	var err error
+	if c.keyID != "" {
+		header.KeyID = c.keyID
+		header.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+		err = rpc.WriteSignedRequestHeader(c.trans, header, c.secret, c.buf.Bytes())
+	} else {
+		err = rpc.WriteRequestHeader(c.trans, header)
+	}
+	if err != nil {
+		return seqID, err
+	}
+	if _, err := c.trans.Write(c.buf.Bytes()); err != nil {
+		return seqID, err
+	}
+	return seqID, c.trans.Flush()
+}
+
+// receive waits for the next message answering seqID and reads it into result.
+// An Exception message is returned as rpc.ApplicationException. If resend is
+// true the request is sent again on every timeout until retries run out.
+func (c *Client) receive(ctx context.Context, seqID int32, result resultReader, resend bool) error {
+	for attempt := 0; ; attempt++ {
+		timeout := c.Timeout
+		if deadline, ok := ctx.Deadline(); ok {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return context.DeadlineExceeded
+			}
+			if timeout <= 0 || remaining < timeout {
+				timeout = remaining
+			}
+		}
+
+		err := c.trans.Receive(timeout)
+		if isTimeout(err) && resend && attempt < c.Retries && ctx.Err() == nil {
+			if err := c.trans.Resend(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		_, typeID, replySeqID, err := c.iprot.ReadMessageBegin()
+		if err != nil {
+			return err
+		}
+		if replySeqID != seqID {
+			// late reply to an earlier call
+			continue
+		}
+		if typeID == rpc.Exception {
+			appErr := rpc.NewApplicationException(rpc.UnknownApplicationExceptionID, "")
+			if err := appErr.Read(c.iprot); err != nil {
+				return err
+			}
+			c.iprot.ReadMessageEnd()
+			return appErr
+		}
+		if err := result.read(c.iprot); err != nil {
+			return err
+		}
+		return c.iprot.ReadMessageEnd()
+	}
+}
+
+// call makes a call of method and waits for its reply
+func (c *Client) call(ctx context.Context, method string, args argsWriter, result resultReader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seqID, err := c.send(method, rpc.Call, args)
+	if err != nil {
+		return err
+	}
+	return c.receive(ctx, seqID, result, true)
+}
+
+func isTimeout(err error) bool {
+	if e, ok := err.(rpc.TransportException); ok {
+		return e.TypeID() == rpc.TimedOutID
+	}
+	return false
+}
+
+// GetFlight returns the flight with the given ID
+func (c *Client) GetFlight(ctx context.Context, id string) (*Flight, error) {
+	res, err := c.getFlight(ctx, &getFlightArgs{id: id})
+	if err != nil {
+		return nil, err
+	}
+	return flightFromInfo(res.flight), nil
+}
+
+// Reserve reserves seats on a flight and returns the booking reference
+func (c *Client) Reserve(ctx context.Context, id string, seats int32) (string, error) {
+	res, err := c.reserve(ctx, &reserveArgs{id: id, seats: seats})
+	if err != nil {
+		return "", err
+	}
+	return res.bookingRef, nil
+}
+
+// CancelReservation cancels the reservation with the given booking reference
+func (c *Client) CancelReservation(ctx context.Context, bookingRef string) error {
+	return c.call(ctx, "cancelReservation", &cancelReservationArgs{bookingRef: bookingRef}, &voidResult{})
+}
+
+// MonitorSeats registers for updates of the available seats of a flight for
+// the given duration, see MonitorFlights
+func (c *Client) MonitorSeats(ctx context.Context, id string, duration time.Duration, fn func(update *MonitorUpdate)) error {
+	return c.MonitorFlights(ctx, &MonitorQuery{FlightIDs: []string{id}}, duration, fn)
+}
+
+// MonitorFlights registers for updates of the flights selected by query for
+// the given duration, calling fn with the available seats of every covered
+// flight and then on every change of seats or fare, new flight of the route
+// and renewal. It blocks until the server ends the monitoring or ctx is done,
+// a renewal of the subscription extends the wait. If ctx is done first the
+// subscription is cancelled on the server.
+func (c *Client) MonitorFlights(ctx context.Context, query *MonitorQuery, duration time.Duration, fn func(update *MonitorUpdate)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	args := &monitorSeatsArgs{
+		durationMs: int32(duration / time.Millisecond),
+		reliable:   true,
+		flightIDs:  query.FlightIDs,
+		from:       query.From,
+		to:         query.To,
+	}
+	seqID, err := c.sendMonitorSeats(args)
+	if err != nil {
+		return err
+	}
+
+	// allow the final message of the server some time to arrive
+	deadline := time.Now().Add(duration + c.Timeout)
+
+	// the request is only resent until the first update shows it arrived
+	var subscriptionID string
+	seen := make(map[int32]bool)
+	for {
+		monitorCtx, cancel := context.WithDeadline(ctx, deadline)
+		res, err := c.receiveMonitorSeats(monitorCtx, seqID, subscriptionID == "")
+		cancel()
+		switch {
+		case err == nil:
+			subscriptionID = res.subscriptionID
+			c.send("ackMonitor", rpc.Oneway, &ackMonitorArgs{subscriptionID: subscriptionID, sequence: res.sequence})
+			if seen[res.sequence] {
+				// retransmission of an update already seen
+				continue
+			}
+			seen[res.sequence] = true
+			update := res.update()
+			deadline = update.ExpiresAt.Add(c.Timeout)
+			fn(update)
+		case subscriptionID == "":
+			return err
+		case isTimeout(err):
+			// no change of the flights, keep waiting
+		case err == context.DeadlineExceeded || err == context.Canceled:
+			if ctx.Err() != nil {
+				c.cancelMonitor(subscriptionID)
+			}
+			return nil
+		default:
+			if ErrorCode(err) == MonitorClosed {
+				// the server closes the monitoring with an exception
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// cancelMonitor cancels a subscription after its caller stopped listening,
+// the caller must hold c.mu
+func (c *Client) cancelMonitor(subscriptionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout*time.Duration(c.Retries+1))
+	defer cancel()
+
+	seqID, err := c.send("cancelMonitor", rpc.Call, &cancelMonitorArgs{subscriptionID: subscriptionID})
+	if err != nil {
+		return err
+	}
+	return c.receive(ctx, seqID, &voidResult{}, true)
+}
+
+// CancelMonitor ends a flight monitor subscription before it expires
+func (c *Client) CancelMonitor(ctx context.Context, subscriptionID string) error {
+	return c.call(ctx, "cancelMonitor", &cancelMonitorArgs{subscriptionID: subscriptionID}, &voidResult{})
+}
+
+// RenewMonitor makes a flight monitor subscription expire after the given
+// duration from now, returning the new expiry
+func (c *Client) RenewMonitor(ctx context.Context, subscriptionID string, duration time.Duration) (time.Time, error) {
+	args := &renewMonitorArgs{subscriptionID: subscriptionID, durationMs: int32(duration / time.Millisecond)}
+	res := &renewMonitorResult{}
+	if err := c.call(ctx, "renewMonitor", args, res); err != nil {
+		return time.Time{}, err
+	}
+	return res.expiresAt, nil
+}
+
+// ListMonitors returns the active flight monitor subscriptions of this client's
+// address
+func (c *Client) ListMonitors(ctx context.Context) ([]*Monitor, error) {
+	res := &listMonitorsResult{}
+	if err := c.call(ctx, "listMonitors", &noArgs{}, res); err != nil {
+		return nil, err
+	}
+	return res.monitors(), nil
+}
+
+// FindFlights returns the IDs of flights from the source to the destination
+func (c *Client) FindFlights(ctx context.Context, from, to string) ([]string, error) {
+	res, err := c.findFlights(ctx, &findFlightsArgs{from: from, to: to})
+	if err != nil {
+		return nil, err
+	}
+	return res.flightIDs, nil
+}
+
+// SearchFlights returns the flights matching the query
+func (c *Client) SearchFlights(ctx context.Context, query *SearchQuery) ([]*Flight, error) {
+	res := &searchFlightsResult{}
+	if err := c.call(ctx, "searchFlights", query, res); err != nil {
+		return nil, err
+	}
+	return res.flights, nil
+}
+
+// NewFlight creates a flight
+func (c *Client) NewFlight(ctx context.Context, flight *Flight) error {
+	return c.newFlight(ctx, flight.newFlightArgs())
+}
+
+// UpdateFlight changes the fields of a flight set in update, returning the
+// updated flight. A flight with active reservations can't be changed.
+func (c *Client) UpdateFlight(ctx context.Context, id string, update *FlightUpdate) (*Flight, error) {
+	res := &getFlightResult{}
+	if err := c.call(ctx, "updateFlight", &updateFlightArgs{id: id, update: update}, res); err != nil {
+		return nil, err
+	}
+	return flightFromInfo(res.flight), nil
+}
+
+// DeleteFlight removes a flight without active reservations
+func (c *Client) DeleteFlight(ctx context.Context, id string) error {
+	return c.call(ctx, "deleteFlight", &getFlightArgs{id: id}, &voidResult{})
+}
+
+// FindDestinations returns the destinations of flights from the source
+func (c *Client) FindDestinations(ctx context.Context, from string) ([]string, error) {
+	res, err := c.findDestinations(ctx, &findDestinationsArgs{from: from})
+	if err != nil {
+		return nil, err
+	}
+	return res.destinations, nil
+}
@@ -0,0 +1,27 @@
+package client
+
+import "github.com/felixputera/cz4013-flight-info/server/rpc"
+
+// Codes of the exceptions flight methods fail with, next to the IDs of the
+// rpc application exceptions
+const (
+	FlightNotFound int32 = 100 + iota
+	InsufficientSeats
+	DuplicateFlight
+	InvalidArgument
+	ReservationNotFound
+	FlightReserved
+	MonitorNotFound
+	TooManyMonitors
+	// MonitorClosed ends the updates of a monitor subscription
+	MonitorClosed
+)
+
+// ErrorCode returns the code of the exception a call failed with, or
+// rpc.UnknownApplicationExceptionID if err isn't an exception
+func ErrorCode(err error) int32 {
+	if e, ok := err.(rpc.ApplicationException); ok {
+		return e.TypeID()
+	}
+	return rpc.UnknownApplicationExceptionID
+}
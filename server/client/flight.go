@@ -0,0 +1,568 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/rpc"
+)
+
+// Flight type
+type Flight struct {
+	ID             string
+	From           string
+	To             string
+	DepartureTime  time.Time
+	ArrivalTime    time.Time // zero if unknown
+	AvailableSeats int32
+	Fare           float32
+}
+
+func timeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// flightFromInfo returns the flight sent by the server
+func flightFromInfo(info *flightInfo) *Flight {
+	if info == nil {
+		return nil
+	}
+	f := &Flight{
+		ID:             info.id,
+		From:           info.from,
+		To:             info.to,
+		DepartureTime:  millisToTime(info.departureTime),
+		AvailableSeats: info.availableSeats,
+		Fare:           info.fare,
+	}
+	if info.arrivalTime != 0 {
+		f.ArrivalTime = millisToTime(info.arrivalTime)
+	}
+	return f
+}
+
+// newFlightArgs returns the arguments of newFlight creating the flight
+func (f *Flight) newFlightArgs() *newFlightArgs {
+	args := &newFlightArgs{
+		id:             f.ID,
+		from:           f.From,
+		to:             f.To,
+		availableSeats: f.AvailableSeats,
+		fare:           f.Fare,
+		departureTime:  timeToMillis(f.DepartureTime),
+	}
+	if !f.ArrivalTime.IsZero() {
+		args.arrivalTime = timeToMillis(f.ArrivalTime)
+	}
+	return args
+}
+
+type voidResult struct{}
+
+func (r *voidResult) read(iprot rpc.Protocol) error {
+	_, _, _, err := iprot.ReadFieldBegin()
+	return err
+}
+
+// FlightUpdate holds the fields changed by UpdateFlight, nil fields are kept.
+// A zero ArrivalTime makes the arrival time unknown.
+type FlightUpdate struct {
+	DepartureTime  *time.Time
+	ArrivalTime    *time.Time
+	AvailableSeats *int32
+	Fare           *float32
+}
+
+type updateFlightArgs struct {
+	id     string
+	update *FlightUpdate
+}
+
+func (a *updateFlightArgs) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("id", rpc.String, 1); err != nil {
+		return
+	}
+	if err = oprot.WriteString(a.id); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if a.update.DepartureTime != nil {
+		if err = oprot.WriteFieldBegin("departureTime", rpc.I64, 2); err != nil {
+			return
+		}
+		if err = oprot.WriteI64(timeToMillis(*a.update.DepartureTime)); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if a.update.ArrivalTime != nil {
+		var ms int64
+		if !a.update.ArrivalTime.IsZero() {
+			ms = timeToMillis(*a.update.ArrivalTime)
+		}
+		if err = oprot.WriteFieldBegin("arrivalTime", rpc.I64, 3); err != nil {
+			return
+		}
+		if err = oprot.WriteI64(ms); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if a.update.AvailableSeats != nil {
+		if err = oprot.WriteFieldBegin("availableSeats", rpc.I32, 4); err != nil {
+			return
+		}
+		if err = oprot.WriteI32(*a.update.AvailableSeats); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if a.update.Fare != nil {
+		if err = oprot.WriteFieldBegin("fare", rpc.Float, 5); err != nil {
+			return
+		}
+		if err = oprot.WriteFloat(*a.update.Fare); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+type cancelReservationArgs struct {
+	bookingRef string
+}
+
+func (a *cancelReservationArgs) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("bookingRef", rpc.String, 1); err != nil {
+		return
+	}
+	if err = oprot.WriteString(a.bookingRef); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+// MonitorQuery selects the flights covered by a monitor subscription: the
+// listed flights, and the flights of a route if From or To is set
+type MonitorQuery struct {
+	FlightIDs []string
+	From      string
+	To        string
+}
+
+// Events of monitor updates
+const (
+	// EventSeats carries the available seats of a flight, sent for every
+	// covered flight when the subscription starts and whenever they change
+	EventSeats = "seats"
+	// EventFare is sent when the fare of a flight changes
+	EventFare = "fare"
+	// EventNewFlight is sent when a flight matching the route is added
+	EventNewFlight = "newFlight"
+	// EventSchedule is sent when the departure or arrival time of a flight
+	// changes
+	EventSchedule = "schedule"
+	// EventRemoved is sent when a flight is deleted
+	EventRemoved = "removed"
+	// EventRenewed is sent when the subscription is renewed and carries no
+	// flight
+	EventRenewed = "renewed"
+)
+
+// MonitorUpdate is a callback of a flight monitor, sent when a covered flight
+// changes or is added, or the subscription is renewed
+type MonitorUpdate struct {
+	SubscriptionID string
+	Event          string
+	FlightID       string
+	Seats          int32
+	Fare           float32
+	DepartureTime  time.Time
+	ArrivalTime    time.Time // zero if unknown
+	// ExpiresAt is when the subscription expires, in local time
+	ExpiresAt time.Time
+}
+
+// update returns the update carried by a reply to monitorSeats
+func (r *monitorSeatsResult) update() *MonitorUpdate {
+	update := &MonitorUpdate{
+		SubscriptionID: r.subscriptionID,
+		Event:          r.event,
+		FlightID:       r.flightID,
+		Seats:          r.seats,
+		Fare:           r.fare,
+		ExpiresAt:      time.Now().Add(time.Duration(r.expiresInMs) * time.Millisecond),
+	}
+	if r.departureTime != 0 {
+		update.DepartureTime = millisToTime(r.departureTime)
+	}
+	if r.arrivalTime != 0 {
+		update.ArrivalTime = millisToTime(r.arrivalTime)
+	}
+	return update
+}
+
+type cancelMonitorArgs struct {
+	subscriptionID string
+}
+
+func (a *cancelMonitorArgs) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("subscriptionID", rpc.String, 1); err != nil {
+		return
+	}
+	if err = oprot.WriteString(a.subscriptionID); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+type ackMonitorArgs struct {
+	subscriptionID string
+	sequence       int32
+}
+
+func (a *ackMonitorArgs) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("subscriptionID", rpc.String, 1); err != nil {
+		return
+	}
+	if err = oprot.WriteString(a.subscriptionID); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldBegin("sequence", rpc.I32, 2); err != nil {
+		return
+	}
+	if err = oprot.WriteI32(a.sequence); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+type renewMonitorArgs struct {
+	subscriptionID string
+	durationMs     int32
+}
+
+func (a *renewMonitorArgs) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("subscriptionID", rpc.String, 1); err != nil {
+		return
+	}
+	if err = oprot.WriteString(a.subscriptionID); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldBegin("durationMs", rpc.I32, 2); err != nil {
+		return
+	}
+	if err = oprot.WriteI32(a.durationMs); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+type renewMonitorResult struct {
+	expiresAt time.Time
+}
+
+func (r *renewMonitorResult) read(iprot rpc.Protocol) error {
+	for {
+		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return rpc.PrependError(fmt.Sprintf("%T field %d read error: ", r, fieldID), err)
+		}
+		if fieldType == rpc.Stop {
+			break
+		}
+		switch fieldID {
+		case 1:
+			if fieldType == rpc.I64 {
+				ms, err := iprot.ReadI64()
+				if err != nil {
+					return rpc.PrependError("failed reading field 1 content", err)
+				}
+				r.expiresAt = millisToTime(ms)
+			} else {
+				return errors.New("field 1 is not i64 type")
+			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		}
+
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Monitor is an active flight monitor subscription
+type Monitor struct {
+	SubscriptionID string
+	Query          MonitorQuery
+	ExpiresAt      time.Time
+}
+
+// monitorInfo is a monitor as listed by the server
+type monitorInfo struct {
+	SubscriptionID string    `rpc:"1,string"`
+	FlightIDs      []string  `rpc:"2,list"`
+	ExpiresAt      time.Time `rpc:"3,i64"`
+	From           string    `rpc:"4,string"`
+	To             string    `rpc:"5,string"`
+}
+
+type noArgs struct{}
+
+func (a *noArgs) write(oprot rpc.Protocol) error {
+	return oprot.WriteFieldStop()
+}
+
+type listMonitorsResult struct {
+	Monitors []*monitorInfo `rpc:"1,list"`
+}
+
+func (r *listMonitorsResult) read(iprot rpc.Protocol) error {
+	return rpc.Unmarshal(iprot, r)
+}
+
+// monitors returns the listed monitors
+func (r *listMonitorsResult) monitors() []*Monitor {
+	monitors := make([]*Monitor, 0, len(r.Monitors))
+	for _, info := range r.Monitors {
+		monitors = append(monitors, &Monitor{
+			SubscriptionID: info.SubscriptionID,
+			Query:          MonitorQuery{FlightIDs: info.FlightIDs, From: info.From, To: info.To},
+			ExpiresAt:      info.ExpiresAt,
+		})
+	}
+	return monitors
+}
+
+// SearchQuery holds the filters of a flight search, zero valued filters are not applied
+type SearchQuery struct {
+	From         string
+	To           string
+	DepartAfter  time.Time
+	DepartBefore time.Time
+	MaxFare      float32
+	MinSeats     int32
+
+	// SortBy is one of "departure" (default), "fare" or "seats"
+	SortBy     string
+	Descending bool
+	Limit      int32
+	Offset     int32
+}
+
+func (q *SearchQuery) write(oprot rpc.Protocol) (err error) {
+	if q.From != "" {
+		if err = oprot.WriteFieldBegin("from", rpc.String, 1); err != nil {
+			return
+		}
+		if err = oprot.WriteString(q.From); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.To != "" {
+		if err = oprot.WriteFieldBegin("to", rpc.String, 2); err != nil {
+			return
+		}
+		if err = oprot.WriteString(q.To); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if !q.DepartAfter.IsZero() {
+		if err = oprot.WriteFieldBegin("departAfter", rpc.I64, 3); err != nil {
+			return
+		}
+		if err = oprot.WriteI64(timeToMillis(q.DepartAfter)); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if !q.DepartBefore.IsZero() {
+		if err = oprot.WriteFieldBegin("departBefore", rpc.I64, 4); err != nil {
+			return
+		}
+		if err = oprot.WriteI64(timeToMillis(q.DepartBefore)); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.MaxFare > 0 {
+		if err = oprot.WriteFieldBegin("maxFare", rpc.Float, 5); err != nil {
+			return
+		}
+		if err = oprot.WriteFloat(q.MaxFare); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.MinSeats > 0 {
+		if err = oprot.WriteFieldBegin("minSeats", rpc.I32, 6); err != nil {
+			return
+		}
+		if err = oprot.WriteI32(q.MinSeats); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.SortBy != "" {
+		if err = oprot.WriteFieldBegin("sortBy", rpc.String, 7); err != nil {
+			return
+		}
+		if err = oprot.WriteString(q.SortBy); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.Descending {
+		if err = oprot.WriteFieldBegin("descending", rpc.Bool, 8); err != nil {
+			return
+		}
+		if err = oprot.WriteBool(q.Descending); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.Limit > 0 {
+		if err = oprot.WriteFieldBegin("limit", rpc.I32, 9); err != nil {
+			return
+		}
+		if err = oprot.WriteI32(q.Limit); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if q.Offset > 0 {
+		if err = oprot.WriteFieldBegin("offset", rpc.I32, 10); err != nil {
+			return
+		}
+		if err = oprot.WriteI32(q.Offset); err != nil {
+			return
+		}
+		if err = oprot.WriteFieldEnd(); err != nil {
+			return
+		}
+	}
+	if err = oprot.WriteFieldStop(); err != nil {
+		return
+	}
+	return nil
+}
+
+type searchFlightsResult struct {
+	flights []*Flight
+}
+
+func (r *searchFlightsResult) read(iprot rpc.Protocol) error {
+	for {
+		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return rpc.PrependError(fmt.Sprintf("%T field %d read error: ", r, fieldID), err)
+		}
+		if fieldType == rpc.Stop {
+			break
+		}
+		switch fieldID {
+		case 1:
+			if fieldType == rpc.List {
+				_, size, err := iprot.ReadListBegin()
+				if err != nil {
+					return rpc.PrependError("failed reading field 1 content", err)
+				}
+				r.flights = make([]*Flight, 0, size)
+				for i := 0; i < size; i++ {
+					info := &flightInfo{}
+					if err := info.read(iprot); err != nil {
+						return rpc.PrependError("failed reading field 1 content", err)
+					}
+					r.flights = append(r.flights, flightFromInfo(info))
+				}
+				if err := iprot.ReadListEnd(); err != nil {
+					return err
+				}
+			} else {
+				return errors.New("field 1 is not list type")
+			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		}
+
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
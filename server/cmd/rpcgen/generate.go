@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+)
+
+// generator writes the Go code of an IDL file
+type generator struct {
+	buf bytes.Buffer
+	// depth of the list being read or written, naming its loop variables
+	depth int
+}
+
+func (g *generator) P(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *generator) doc(doc []string) {
+	for _, line := range doc {
+		g.P("// %s", line)
+	}
+}
+
+// Generate returns the formatted Go source of the types of f and its
+// services, server processors if server is true, client methods otherwise
+func Generate(f *File, source, pkg, rpcImport string, server bool) ([]byte, error) {
+	if err := checkNames(f); err != nil {
+		return nil, err
+	}
+	g := &generator{}
+	g.P("// Code generated by rpcgen from %s. DO NOT EDIT.", source)
+	g.P("")
+	g.P("package %s", pkg)
+	g.P("")
+	g.P("import (")
+	if len(f.Services) > 0 {
+		g.P("%q", "context")
+	}
+	structs, fields := countStructs(f)
+	if fields > 0 {
+		g.P("%q", "errors")
+	}
+	if structs > 0 {
+		g.P("%q", "fmt")
+	}
+	g.P("")
+	g.P("%q", rpcImport)
+	g.P(")")
+
+	for _, s := range f.Structs {
+		g.P("")
+		g.doc(structDoc(s))
+		g.structType(typeName(s.Name), s.Fields)
+	}
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			g.P("")
+			g.P("// %s holds the arguments of %s", argsName(m), m.Name)
+			g.structType(argsName(m), m.Args)
+			g.P("")
+			g.P("// %s holds the results of %s", resultName(m), m.Name)
+			g.structType(resultName(m), m.Results)
+		}
+		if server {
+			g.serverService(s)
+		} else {
+			g.clientService(s)
+		}
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %v", err)
+	}
+	return src, nil
+}
+
+// countStructs returns the number of generated structs and of their fields
+func countStructs(f *File) (structs, fields int) {
+	for _, s := range f.Structs {
+		structs++
+		fields += len(s.Fields)
+	}
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			structs += 2
+			fields += len(m.Args) + len(m.Results)
+		}
+	}
+	return structs, fields
+}
+
+// checkNames checks that the generated names don't collide and field names
+// can be Go identifiers
+func checkNames(f *File) error {
+	names := make(map[string]string)
+	use := func(name, what string) error {
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s are both generated as %s", what, other, name)
+		}
+		names[name] = what
+		return nil
+	}
+	checkFields := func(fields []*Field) error {
+		for _, field := range fields {
+			if !token.IsIdentifier(field.Name) {
+				return fmt.Errorf("field %s is not a Go identifier", field.Name)
+			}
+		}
+		return nil
+	}
+	for _, s := range f.Structs {
+		if err := use(typeName(s.Name), "struct "+s.Name); err != nil {
+			return err
+		}
+		if err := checkFields(s.Fields); err != nil {
+			return err
+		}
+	}
+	for _, s := range f.Services {
+		if err := use(handlerName(s), "service "+s.Name); err != nil {
+			return err
+		}
+		for _, m := range s.Methods {
+			what := "method " + s.Name + "." + m.Name
+			for _, name := range []string{argsName(m), resultName(m), processorName(m), streamName(m)} {
+				if err := use(name, what); err != nil {
+					return err
+				}
+			}
+			if err := checkFields(m.Args); err != nil {
+				return err
+			}
+			if err := checkFields(m.Results); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+func upperFirst(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// structDoc is the doc of a struct, naming its Go type if it starts with the
+// name of the struct
+func structDoc(s *Struct) []string {
+	if len(s.Doc) == 0 || !strings.HasPrefix(s.Doc[0]+" ", s.Name+" ") {
+		return s.Doc
+	}
+	return append([]string{typeName(s.Name) + s.Doc[0][len(s.Name):]}, s.Doc[1:]...)
+}
+
+// typeName is the unexported Go type of a struct
+func typeName(name string) string {
+	return lowerFirst(name)
+}
+
+func argsName(m *Method) string {
+	return m.Name + "Args"
+}
+
+func resultName(m *Method) string {
+	return m.Name + "Result"
+}
+
+func processorName(m *Method) string {
+	return m.Name + "Processor"
+}
+
+func streamName(m *Method) string {
+	return m.Name + "Stream"
+}
+
+func handlerName(s *Service) string {
+	return lowerFirst(s.Name) + "Handler"
+}
+
+// fieldName is the Go name of a field, keywords get an underscore appended
+func fieldName(field *Field) string {
+	if token.IsKeyword(field.Name) {
+		return field.Name + "_"
+	}
+	return field.Name
+}
+
+// goType is the Go type of t
+func goType(t *Type) string {
+	switch {
+	case t.Elem != nil:
+		return "[]" + goType(t.Elem)
+	case t.Struct != nil:
+		return "*" + typeName(t.Struct.Name)
+	}
+	switch t.Name {
+	case "i16":
+		return "int16"
+	case "i32":
+		return "int32"
+	case "i64":
+		return "int64"
+	case "float":
+		return "float32"
+	case "binary":
+		return "[]byte"
+	}
+	return t.Name
+}
+
+// wireType is the rpc.Type t is encoded as
+func wireType(t *Type) string {
+	switch {
+	case t.Elem != nil:
+		return "rpc.List"
+	case t.Struct != nil:
+		return "rpc.Struct"
+	}
+	switch t.Name {
+	case "bool":
+		return "rpc.Bool"
+	case "byte":
+		return "rpc.Byte"
+	case "i16":
+		return "rpc.I16"
+	case "i32":
+		return "rpc.I32"
+	case "i64":
+		return "rpc.I64"
+	case "float":
+		return "rpc.Float"
+	}
+	return "rpc.String"
+}
+
+// protocolMethod is the suffix of the rpc.Protocol methods reading and writing
+// a base type
+func protocolMethod(t *Type) string {
+	switch t.Name {
+	case "bool", "byte", "float", "string", "binary":
+		return upperFirst(t.Name)
+	}
+	return strings.ToUpper(t.Name)
+}
+
+// typeString is t as written in the IDL
+func typeString(t *Type) string {
+	if t.Elem != nil {
+		return "list<" + typeString(t.Elem) + ">"
+	}
+	return t.Name
+}
+
+// isSet is the condition an optional field is written on
+func isSet(field *Field, value string) string {
+	t := field.Type
+	switch {
+	case t.Elem != nil || t.Name == "binary":
+		return "len(" + value + ") > 0"
+	case t.Struct != nil:
+		return value + " != nil"
+	case t.Name == "bool":
+		return value
+	case t.Name == "string":
+		return value + ` != ""`
+	}
+	return value + " != 0"
+}
+
+func (g *generator) structType(name string, fields []*Field) {
+	g.P("type %s struct {", name)
+	for _, field := range fields {
+		g.doc(field.Doc)
+		g.P("%s %s", fieldName(field), goType(field.Type))
+	}
+	g.P("}")
+	g.P("")
+	g.writeMethod(name, fields)
+	g.P("")
+	g.readMethod(name, fields)
+}
+
+func (g *generator) writeMethod(name string, fields []*Field) {
+	g.P("func (s *%s) write(oprot rpc.Protocol) (err error) {", name)
+	for _, field := range fields {
+		value := "s." + fieldName(field)
+		if field.Optional {
+			g.P("if %s {", isSet(field, value))
+		} else if field.Type.Struct != nil {
+			g.P("if %s == nil {", value)
+			g.P("return errors.New(\"field %s of %s is not set\")", field.Name, name)
+			g.P("}")
+		}
+		g.P("if err = oprot.WriteFieldBegin(%q, %s, %d); err != nil {", field.Name, wireType(field.Type), field.ID)
+		g.P("return")
+		g.P("}")
+		g.writeValue(field.Type, value)
+		g.P("if err = oprot.WriteFieldEnd(); err != nil {")
+		g.P("return")
+		g.P("}")
+		if field.Optional {
+			g.P("}")
+		}
+	}
+	g.P("return oprot.WriteFieldStop()")
+	g.P("}")
+}
+
+func (g *generator) writeValue(t *Type, value string) {
+	switch {
+	case t.Elem != nil:
+		g.depth++
+		elem := fmt.Sprintf("elem%d", g.depth)
+		g.P("if err = oprot.WriteListBegin(%s, len(%s)); err != nil {", wireType(t.Elem), value)
+		g.P("return")
+		g.P("}")
+		g.P("for _, %s := range %s {", elem, value)
+		if t.Elem.Struct != nil {
+			g.P("if %s == nil {", elem)
+			g.P("return errors.New(\"nil element in list of %s\")", t.Elem.Struct.Name)
+			g.P("}")
+		}
+		g.writeValue(t.Elem, elem)
+		g.P("}")
+		g.P("if err = oprot.WriteListEnd(); err != nil {")
+		g.P("return")
+		g.P("}")
+		g.depth--
+	case t.Struct != nil:
+		g.P("if err = %s.write(oprot); err != nil {", value)
+		g.P("return")
+		g.P("}")
+	default:
+		g.P("if err = oprot.Write%s(%s); err != nil {", protocolMethod(t), value)
+		g.P("return")
+		g.P("}")
+	}
+}
+
+func (g *generator) readMethod(name string, fields []*Field) {
+	g.P("func (s *%s) read(iprot rpc.Protocol) error {", name)
+	g.P("for {")
+	g.P("_, fieldType, fieldID, err := iprot.ReadFieldBegin()")
+	g.P("if err != nil {")
+	g.P("return rpc.PrependError(fmt.Sprintf(\"%%T field %%d read error: \", s, fieldID), err)")
+	g.P("}")
+	g.P("if fieldType == rpc.Stop {")
+	g.P("break")
+	g.P("}")
+	g.P("switch fieldID {")
+	for _, field := range fields {
+		g.P("case %d:", field.ID)
+		g.P("if fieldType != %s {", wireType(field.Type))
+		g.P("return errors.New(\"field %d is not %s type\")", field.ID, typeString(field.Type))
+		g.P("}")
+		g.readValue(field, field.Type, "s."+fieldName(field))
+	}
+	g.P("default:")
+	g.P("if err := rpc.Skip(iprot, fieldType); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("}")
+	g.P("if err := iprot.ReadFieldEnd(); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+}
+
+// readValue reads a value of type t into target, the wire type of the value
+// has been checked
+func (g *generator) readValue(field *Field, t *Type, target string) {
+	failed := fmt.Sprintf("return rpc.PrependError(\"failed reading field %d content: \", err)", field.ID)
+	switch {
+	case t.Elem != nil:
+		g.depth++
+		elemType := fmt.Sprintf("elemType%d", g.depth)
+		size := fmt.Sprintf("size%d", g.depth)
+		i := fmt.Sprintf("i%d", g.depth)
+		elem := fmt.Sprintf("elem%d", g.depth)
+		g.P("{")
+		g.P("%s, %s, err := iprot.ReadListBegin()", elemType, size)
+		g.P("if err != nil {")
+		g.P(failed)
+		g.P("}")
+		g.P("if %s > 0 && %s != %s {", size, elemType, wireType(t.Elem))
+		g.P("return errors.New(\"field %d is not %s type\")", field.ID, typeString(field.Type))
+		g.P("}")
+		g.P("%s = make(%s, 0, %s)", target, goType(t), size)
+		g.P("for %s := 0; %s < %s; %s++ {", i, i, size, i)
+		g.P("var %s %s", elem, goType(t.Elem))
+		g.readValue(field, t.Elem, elem)
+		g.P("%s = append(%s, %s)", target, target, elem)
+		g.P("}")
+		g.P("if err := iprot.ReadListEnd(); err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("}")
+		g.depth--
+	case t.Struct != nil:
+		g.P("%s = &%s{}", target, typeName(t.Struct.Name))
+		g.P("if err := %s.read(iprot); err != nil {", target)
+		g.P(failed)
+		g.P("}")
+	default:
+		g.P("if %s, err = iprot.Read%s(); err != nil {", target, protocolMethod(t))
+		g.P(failed)
+		g.P("}")
+	}
+}
+
+// serverService writes the handler interface of a service and the processors
+// of its methods
+func (g *generator) serverService(s *Service) {
+	handler := handlerName(s)
+	g.P("")
+	if len(s.Doc) > 0 {
+		g.doc(s.Doc)
+		g.P("//")
+	}
+	g.P("// %s implements the methods of service %s. client is the", handler, s.Name)
+	g.P("// address of the caller, errors are replied to with an exception, see")
+	g.P("// rpc.NewMethodException.")
+	g.P("type %s interface {", handler)
+	for _, m := range s.Methods {
+		g.doc(m.Doc)
+		switch {
+		case m.Stream:
+			g.P("%s(ctx context.Context, client string, args *%s, stream *%s) error", m.Name, argsName(m), streamName(m))
+		case len(m.Results) == 0:
+			g.P("%s(ctx context.Context, client string, args *%s) error", m.Name, argsName(m))
+		default:
+			g.P("%s(ctx context.Context, client string, args *%s) (*%s, error)", m.Name, argsName(m), resultName(m))
+		}
+	}
+	g.P("}")
+
+	g.P("")
+	g.P("// add%sProcessors adds the processors of the methods of service %s", upperFirst(s.Name), s.Name)
+	g.P("// calling handler to methodMap")
+	g.P("func add%sProcessors(methodMap map[string]rpc.ProcessorFunction, handler %s) {", upperFirst(s.Name), handler)
+	for _, m := range s.Methods {
+		g.P("methodMap[%q] = &%s{handler: handler}", m.Name, processorName(m))
+	}
+	g.P("}")
+
+	for _, m := range s.Methods {
+		if m.Stream {
+			g.streamType(m)
+		}
+		g.processor(s, m)
+	}
+}
+
+func (g *generator) streamType(m *Method) {
+	name := streamName(m)
+	g.P("")
+	g.P("// %s sends the replies of a %s call, also after the", name, m.Name)
+	g.P("// handler returned")
+	g.P("type %s struct {", name)
+	g.P("oprot rpc.Protocol")
+	g.P("seqID int32")
+	g.P("}")
+	g.P("")
+	g.P("// send replies with the next result of the call")
+	g.P("func (s *%s) send(res *%s) error {", name, resultName(m))
+	g.P("if err := s.oprot.WriteMessageBegin(%q, rpc.Reply, s.seqID); err != nil {", m.Name)
+	g.P("return err")
+	g.P("}")
+	g.P("if err := res.write(s.oprot); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("if err := s.oprot.WriteMessageEnd(); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("return s.oprot.Flush()")
+	g.P("}")
+	g.P("")
+	g.P("// close ends the replies of the call with the exception for err")
+	g.P("func (s *%s) close(err error) error {", name)
+	g.P("return rpc.WriteException(s.oprot, %q, s.seqID, rpc.NewMethodException(%q, err))", m.Name, m.Name)
+	g.P("}")
+}
+
+func (g *generator) processor(s *Service, m *Method) {
+	name := processorName(m)
+	g.P("")
+	g.P("type %s struct {", name)
+	g.P("handler %s", handlerName(s))
+	g.P("}")
+	g.P("")
+	g.P("func (p *%s) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {", name)
+	g.P("args := &%s{}", argsName(m))
+	g.P("if err := args.read(iprot); err != nil {")
+	g.P("return rpc.ReplyException(oprot, %q, seqID, rpc.NewArgumentsException(%q, err))", m.Name, m.Name)
+	g.P("}")
+	g.P("if err := iprot.ReadMessageEnd(); err != nil {")
+	g.P("return false, err")
+	g.P("}")
+	g.P("")
+	switch {
+	case m.Stream:
+		g.P("stream := &%s{oprot: oprot, seqID: seqID}", streamName(m))
+		g.P("if err := p.handler.%s(ctx, rpc.RemoteAddress(oprot), args, stream); err != nil {", m.Name)
+		g.P("return rpc.ReplyException(oprot, %q, seqID, rpc.NewMethodException(%q, err))", m.Name, m.Name)
+		g.P("}")
+		g.P("return true, nil")
+		g.P("}")
+		return
+	case len(m.Results) == 0:
+		g.P("if err := p.handler.%s(ctx, rpc.RemoteAddress(oprot), args); err != nil {", m.Name)
+	default:
+		g.P("res, err := p.handler.%s(ctx, rpc.RemoteAddress(oprot), args)", m.Name)
+		g.P("if err != nil {")
+	}
+	g.P("return rpc.ReplyException(oprot, %q, seqID, rpc.NewMethodException(%q, err))", m.Name, m.Name)
+	g.P("}")
+	if len(m.Results) == 0 {
+		g.P("res := &%s{}", resultName(m))
+	}
+	g.P("")
+	g.P("if err := oprot.WriteMessageBegin(%q, rpc.Reply, seqID); err != nil {", m.Name)
+	g.P("return false, err")
+	g.P("}")
+	g.P("if err := res.write(oprot); err != nil {")
+	g.P("return false, err")
+	g.P("}")
+	g.P("if err := oprot.WriteMessageEnd(); err != nil {")
+	g.P("return false, err")
+	g.P("}")
+	g.P("return true, oprot.Flush()")
+	g.P("}")
+}
+
+// clientService writes the methods of Client calling the methods of a
+// service. They use the call, send and receive methods of Client.
+func (g *generator) clientService(s *Service) {
+	for _, m := range s.Methods {
+		g.P("")
+		switch {
+		case m.Stream:
+			g.P("// send%s sends a call of %s, its replies are received with", upperFirst(m.Name), m.Name)
+			g.P("// receive%s until an exception ends them", upperFirst(m.Name))
+			g.P("func (c *Client) send%s(args *%s) (int32, error) {", upperFirst(m.Name), argsName(m))
+			g.P("return c.send(%q, rpc.Call, args)", m.Name)
+			g.P("}")
+			g.P("")
+			g.P("// receive%s waits for the next reply to the %s call of seqID,", upperFirst(m.Name), m.Name)
+			g.P("// resending the call on timeouts if resend is true")
+			g.P("func (c *Client) receive%s(ctx context.Context, seqID int32, resend bool) (*%s, error) {", upperFirst(m.Name), resultName(m))
+			g.P("res := &%s{}", resultName(m))
+			g.P("if err := c.receive(ctx, seqID, res, resend); err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return res, nil")
+			g.P("}")
+		case len(m.Results) == 0:
+			g.P("// %s calls %s of service %s", m.Name, m.Name, s.Name)
+			g.P("func (c *Client) %s(ctx context.Context, args *%s) error {", m.Name, argsName(m))
+			g.P("return c.call(ctx, %q, args, &%s{})", m.Name, resultName(m))
+			g.P("}")
+		default:
+			g.P("// %s calls %s of service %s", m.Name, m.Name, s.Name)
+			g.P("func (c *Client) %s(ctx context.Context, args *%s) (*%s, error) {", m.Name, argsName(m), resultName(m))
+			g.P("res := &%s{}", resultName(m))
+			g.P("if err := c.call(ctx, %q, args, res); err != nil {", m.Name)
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return res, nil")
+			g.P("}")
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Command rpcgen generates the Go code of the structs and services described
+// by an IDL file, run by go generate:
+//
+//	//go:generate go run ../cmd/rpcgen -mode server -o flight_rpc.go flight.idl
+//
+// An IDL file declares structs and services, // starts a comment and the
+// comment lines right above a declaration become its doc comment:
+//
+//	struct FlightInfo {
+//		1: string id
+//		// omitted when zero
+//		8: optional i64 arrivalTime
+//	}
+//
+//	service Flight {
+//		getFlight(1: string id) returns (1: FlightInfo flight)
+//		newFlight(1: string id, 2: string from)
+//		stream monitorSeats(1: string id) returns (1: i32 seats)
+//	}
+//
+// Fields are numbered, the number is their ID on the wire. Their type is one
+// of bool, byte, i16, i32, i64, float, string, binary, list<type> or a struct
+// of the file. An optional field is only written when it isn't zero, other
+// fields are always written. Unknown fields are skipped when reading.
+//
+// The arguments and results of a method are structs of their own. A method
+// without results replies with an empty struct, a stream method replies any
+// number of times until it ends with an exception.
+//
+// Every struct gets an unexported Go type with read and write methods, a
+// method m gets the mArgs and mResult types. In server mode a service S gets
+// the sHandler interface implemented by the package, processors calling it
+// and the addSProcessors function registering them. In client mode a method
+// gets a method of the Client type of the package, which must provide the
+// call, send and receive methods.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultRPCImport = "github.com/felixputera/cz4013-flight-info/server/rpc"
+
+func main() {
+	var mode string
+	var out string
+	var pkg string
+	var rpcImport string
+
+	flag.StringVar(&mode, "mode", "server", "code to generate: server or client")
+	flag.StringVar(&out, "o", "", "output file, defaults to the IDL file with the .go extension")
+	flag.StringVar(&pkg, "package", "", "package of the generated code, defaults to $GOPACKAGE")
+	flag.StringVar(&rpcImport, "rpc", defaultRPCImport, "import path of the rpc package")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rpcgen [flags] file.idl")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), out, mode, pkg, rpcImport); err != nil {
+		fmt.Fprintln(os.Stderr, "rpcgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, mode, pkg, rpcImport string) error {
+	if mode != "server" && mode != "client" {
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+	if pkg == "" {
+		// set by go generate
+		pkg = os.Getenv("GOPACKAGE")
+	}
+	if pkg == "" {
+		return fmt.Errorf("no package given")
+	}
+	if out == "" {
+		out = in[:len(in)-len(filepath.Ext(in))] + ".go"
+	}
+
+	src, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	f, err := Parse(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %v", in, err)
+	}
+	code, err := Generate(f, filepath.Base(in), pkg, rpcImport, mode == "server")
+	if err != nil {
+		return fmt.Errorf("%s: %v", in, err)
+	}
+	return ioutil.WriteFile(out, code, 0644)
+}
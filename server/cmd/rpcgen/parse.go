@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// File is a parsed IDL file
+type File struct {
+	Structs  []*Struct
+	Services []*Service
+}
+
+// Struct is a named list of fields
+type Struct struct {
+	Name   string
+	Doc    []string
+	Fields []*Field
+}
+
+// Field is a field of a struct or of the arguments or results of a method
+type Field struct {
+	ID       int16
+	Name     string
+	Type     *Type
+	Optional bool
+	Doc      []string
+}
+
+// Type is a base type, a list or a struct declared in the file
+type Type struct {
+	// Name is the base type, "list" or the name of the struct
+	Name   string
+	Elem   *Type
+	Struct *Struct
+}
+
+// Service is a named list of methods
+type Service struct {
+	Name    string
+	Doc     []string
+	Methods []*Method
+}
+
+// Method is a call of a service. A stream method replies any number of times
+// before ending with an exception.
+type Method struct {
+	Name    string
+	Doc     []string
+	Stream  bool
+	Args    []*Field
+	Results []*Field
+}
+
+var baseTypes = map[string]bool{
+	"bool":   true,
+	"byte":   true,
+	"i16":    true,
+	"i32":    true,
+	"i64":    true,
+	"float":  true,
+	"string": true,
+	"binary": true,
+}
+
+type lexToken struct {
+	text string
+	line int
+	// the comment lines right above the token
+	doc []string
+}
+
+// lex splits src into identifiers, integers and punctuation, dropping
+// comments. The // comment lines right above a token are kept as its doc.
+func lex(src string) ([]lexToken, error) {
+	var tokens []lexToken
+	var doc []string
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		if strings.TrimSpace(line) == "" {
+			doc = nil
+			continue
+		}
+		if c := strings.Index(line, "//"); c >= 0 {
+			if strings.TrimSpace(line[:c]) == "" {
+				doc = append(doc, strings.TrimSpace(line[c+2:]))
+				continue
+			}
+			line = line[:c]
+		}
+		rest := line
+		for {
+			rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+			if rest == "" {
+				break
+			}
+			n := 1
+			switch r := rune(rest[0]); {
+			case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+				n = strings.IndexFunc(rest, func(r rune) bool {
+					return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+				})
+				if n < 0 {
+					n = len(rest)
+				}
+			case strings.ContainsRune("{}()<>:,;", r):
+			default:
+				return nil, fmt.Errorf("line %d: unexpected character %q", lineNo, r)
+			}
+			tokens = append(tokens, lexToken{text: rest[:n], line: lineNo, doc: doc})
+			doc = nil
+			rest = rest[n:]
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []lexToken
+	pos    int
+	line   int
+}
+
+// Parse parses and checks an IDL file
+func Parse(src string) (*File, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f, err := p.parseFile()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", p.line, err)
+	}
+	if err := f.resolve(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *parser) next() lexToken {
+	if p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+		p.line = t.line
+		return t
+	}
+	return lexToken{}
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.text != text {
+		return fmt.Errorf("expected %q, found %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.text == "" || !(t.text[0] == '_' || unicode.IsLetter(rune(t.text[0]))) {
+		return "", fmt.Errorf("expected a name, found %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseFile() (*File, error) {
+	f := &File{}
+	for p.peek() != "" {
+		t := p.next()
+		switch t.text {
+		case "struct":
+			s, err := p.parseStruct(t.doc)
+			if err != nil {
+				return nil, err
+			}
+			f.Structs = append(f.Structs, s)
+		case "service":
+			s, err := p.parseService(t.doc)
+			if err != nil {
+				return nil, err
+			}
+			f.Services = append(f.Services, s)
+		default:
+			return nil, fmt.Errorf("expected struct or service, found %q", t.text)
+		}
+	}
+	return f, nil
+}
+
+// struct Name { 1: type name ... }
+func (p *parser) parseStruct(doc []string) (*Struct, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	fields, err := p.parseFields("}")
+	if err != nil {
+		return nil, err
+	}
+	return &Struct{Name: name, Doc: doc, Fields: fields}, nil
+}
+
+// parseFields parses fields separated by optional commas or semicolons up to
+// the closing token
+func (p *parser) parseFields(end string) ([]*Field, error) {
+	var fields []*Field
+	for {
+		switch p.peek() {
+		case end:
+			p.next()
+			return fields, nil
+		case ",", ";":
+			p.next()
+			continue
+		case "":
+			return nil, fmt.Errorf("expected %q, found end of file", end)
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+// 1: [optional] type name
+func (p *parser) parseField() (*Field, error) {
+	t := p.next()
+	id, err := strconv.ParseInt(t.text, 10, 16)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("expected a positive field ID, found %q", t.text)
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	field := &Field{ID: int16(id), Doc: t.doc}
+	if p.peek() == "optional" {
+		p.next()
+		field.Optional = true
+	}
+	if field.Type, err = p.parseType(); err != nil {
+		return nil, err
+	}
+	if field.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func (p *parser) parseType() (*Type, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if name != "list" {
+		return &Type{Name: name}, nil
+	}
+	if err := p.expect("<"); err != nil {
+		return nil, err
+	}
+	elem, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(">"); err != nil {
+		return nil, err
+	}
+	return &Type{Name: name, Elem: elem}, nil
+}
+
+// service Name { [stream] method(args) [returns (results)] ... }
+func (p *parser) parseService(doc []string) (*Service, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	s := &Service{Name: name, Doc: doc}
+	for p.peek() != "}" {
+		if p.peek() == "" {
+			return nil, fmt.Errorf("expected \"}\", found end of file")
+		}
+		m := &Method{Doc: p.tokens[p.pos].doc}
+		if p.peek() == "stream" {
+			p.next()
+			m.Stream = true
+		}
+		if m.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if m.Args, err = p.parseFields(")"); err != nil {
+			return nil, err
+		}
+		if p.peek() == "returns" {
+			p.next()
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			if m.Results, err = p.parseFields(")"); err != nil {
+				return nil, err
+			}
+		}
+		s.Methods = append(s.Methods, m)
+	}
+	p.next()
+	return s, nil
+}
+
+// resolve links the types of fields to the structs they name and checks that
+// names and field IDs are unique
+func (f *File) resolve() error {
+	structs := make(map[string]*Struct)
+	for _, s := range f.Structs {
+		if structs[s.Name] != nil || baseTypes[s.Name] || s.Name == "list" {
+			return fmt.Errorf("struct %s: name already used", s.Name)
+		}
+		structs[s.Name] = s
+	}
+	var resolveType func(t *Type) error
+	resolveType = func(t *Type) error {
+		switch {
+		case t.Elem != nil:
+			return resolveType(t.Elem)
+		case baseTypes[t.Name]:
+			return nil
+		case structs[t.Name] != nil:
+			t.Struct = structs[t.Name]
+			return nil
+		}
+		return fmt.Errorf("unknown type %s", t.Name)
+	}
+	checkFields := func(where string, fields []*Field) error {
+		ids := make(map[int16]bool)
+		names := make(map[string]bool)
+		for _, field := range fields {
+			if ids[field.ID] {
+				return fmt.Errorf("%s: duplicate field ID %d", where, field.ID)
+			}
+			if names[field.Name] {
+				return fmt.Errorf("%s: duplicate field %s", where, field.Name)
+			}
+			ids[field.ID] = true
+			names[field.Name] = true
+			if err := resolveType(field.Type); err != nil {
+				return fmt.Errorf("%s: field %s: %v", where, field.Name, err)
+			}
+		}
+		return nil
+	}
+	for _, s := range f.Structs {
+		if err := checkFields("struct "+s.Name, s.Fields); err != nil {
+			return err
+		}
+	}
+	for _, s := range f.Services {
+		methods := make(map[string]bool)
+		for _, m := range s.Methods {
+			if methods[m.Name] {
+				return fmt.Errorf("service %s: duplicate method %s", s.Name, m.Name)
+			}
+			methods[m.Name] = true
+			where := fmt.Sprintf("service %s: method %s", s.Name, m.Name)
+			if err := checkFields(where+" arguments", m.Args); err != nil {
+				return err
+			}
+			if err := checkFields(where+" results", m.Results); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
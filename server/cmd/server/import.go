@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/felixputera/cz4013-flight-info/server/database"
+	"github.com/felixputera/cz4013-flight-info/server/flight"
+)
+
+// runImport loads flights from a CSV or JSON file into the sqlite store
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dsn := fs.String("db", "", "DSN of the sqlite store database, defaults to $"+database.DSNEnv+" or "+database.DefaultDSN)
+	format := fs.String("format", "", "format of the file: csv or json, defaults to the file extension")
+	upsert := fs.Bool("upsert", false, "replace flights with an existing flight number instead of rejecting the import")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without changing the database")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server import [flags] FILE")
+		fmt.Fprintln(fs.Output(), "Imports all flights of FILE or none, - reads standard input.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import needs exactly one file")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = formatOf(path)
+	}
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	rows, err := flight.ReadFlights(in, *format)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	store, err := openStore("sqlite", database.DSN(*dsn))
+	if err != nil {
+		return fmt.Errorf("failed to open sqlite store: %v", err)
+	}
+	defer store.Close()
+	flight.Init(store)
+
+	plan, err := flight.PlanImport(rows, *upsert)
+	if err != nil {
+		return err
+	}
+	printImportPlan(os.Stdout, plan)
+
+	switch {
+	case !plan.OK():
+		return fmt.Errorf("%d rows rejected, nothing imported", plan.Rejected)
+	case *dryRun:
+		fmt.Println("dry run, nothing imported")
+		return nil
+	}
+	if err := flight.ImportFlights(plan); err != nil {
+		return fmt.Errorf("import failed, nothing imported: %v", err)
+	}
+	fmt.Printf("imported %d flights\n", plan.New+plan.Updated)
+	return nil
+}
+
+func printImportPlan(w io.Writer, plan *flight.ImportPlan) {
+	for _, row := range plan.Rows {
+		switch {
+		case row.Err != nil:
+			fmt.Fprintf(w, "row %d %s: %s: %v\n", row.Row, row.ID, row.Action, row.Err)
+		case row.Action != flight.ImportUnchanged:
+			fmt.Fprintf(w, "row %d %s: %s\n", row.Row, row.ID, row.Action)
+		}
+	}
+	fmt.Fprintf(w, "%d new, %d updated, %d unchanged, %d rejected\n",
+		plan.New, plan.Updated, plan.Unchanged, plan.Rejected)
+}
+
+// runExport dumps the flights of the sqlite store as CSV or JSON
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dsn := fs.String("db", "", "DSN of the sqlite store database, defaults to $"+database.DSNEnv+" or "+database.DefaultDSN)
+	format := fs.String("format", "", "format of the file: csv or json, defaults to the file extension or csv")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server export [flags] [FILE]")
+		fmt.Fprintln(fs.Output(), "Exports all flights to FILE, or standard output if FILE is - or missing.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("export takes at most one file")
+	}
+
+	path := "-"
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	if *format == "" {
+		*format = formatOf(path)
+	}
+	if *format != flight.FormatCSV && *format != flight.FormatJSON {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	store, err := openStore("sqlite", database.DSN(*dsn))
+	if err != nil {
+		return fmt.Errorf("failed to open sqlite store: %v", err)
+	}
+	defer store.Close()
+	flight.Init(store)
+
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+	}
+	err = flight.ExportFlights(out, *format)
+	if path != "-" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export flights: %v", err)
+	}
+	return nil
+}
+
+// formatOf returns the format of a file by its extension, CSV by default
+func formatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return flight.FormatJSON
+	}
+	return flight.FormatCSV
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
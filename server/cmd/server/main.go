@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/felixputera/cz4013-flight-info/server/database"
 	"github.com/felixputera/cz4013-flight-info/server/flight"
@@ -11,27 +13,207 @@ import (
 )
 
 func main() {
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "import":
+		err = runImport(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "export":
+		err = runExport(os.Args[2:])
+	default:
+		err = run()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "server:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	var filterDuplicate bool
 	var port int
+	var transportName string
+	var cacheSize int
+	var cacheTTL time.Duration
+	var cacheStats time.Duration
+	var storeName string
+	var dsn string
+	var migrateTo int
+	var keysPath string
+	var maxClockSkew time.Duration
+	var pskPath string
+	var replayWindow time.Duration
 
 	flag.BoolVar(&filterDuplicate, "filter", false, "filter duplicate request")
-	flag.IntVar(&port, "port", 12345, "server UDP listen port")
+	flag.IntVar(&port, "port", 12345, "server listen port")
+	flag.StringVar(&transportName, "transport", "udp", "transport to serve requests over: udp, tcp or both")
+	flag.IntVar(&cacheSize, "cache-size", rpc.DefaultCacheSize, "number of replies kept to filter duplicate request")
+	flag.DurationVar(&cacheTTL, "cache-ttl", rpc.DefaultCacheTTL, "how long replies are kept to filter duplicate request")
+	flag.DurationVar(&cacheStats, "cache-stats", 0, "interval to log reply cache statistics, 0 to disable")
+	flag.StringVar(&storeName, "store", "sqlite", "where flights are kept: sqlite or memory")
+	flag.StringVar(&dsn, "db", "", "DSN of the sqlite store database like sqlite3://path, defaults to $"+database.DSNEnv+" or "+database.DefaultDSN)
+	flag.IntVar(&migrateTo, "migrate-to", database.Latest, "migrate the schema of the sqlite store up or down to the given version and exit")
+
+	flag.StringVar(&keysPath, "keys", "", "file of API keys signing requests, admin methods need a key of role "+flight.AdminRole+" if set")
+	flag.DurationVar(&maxClockSkew, "max-clock-skew", rpc.DefaultMaxClockSkew, "how far the timestamp of a signed request may be off the server clock")
+	flag.StringVar(&pskPath, "psk", "", "file of the hex encoded pre-shared AES key encrypting messages, messages are sent in the clear if unset")
+	flag.DurationVar(&replayWindow, "replay-window", rpc.DefaultReplayWindow, "how far the timestamp of an encrypted message may be off the server clock")
+
+	flag.IntVar(&flight.MaxMonitors, "max-monitors", flight.MaxMonitors, "maximum number of active seat monitors")
+	flag.IntVar(&flight.MaxMonitorsPerClient, "max-monitors-per-client", flight.MaxMonitorsPerClient, "maximum number of active seat monitors of a client")
+	flag.DurationVar(&flight.CallbackRetryInterval, "monitor-retry-interval", flight.CallbackRetryInterval, "initial interval between retransmissions of unacknowledged monitor updates")
+	flag.IntVar(&flight.MaxCallbackRetries, "monitor-retries", flight.MaxCallbackRetries, "retransmissions of an unacknowledged monitor update before the subscriber is dropped")
+
+	var faults rpc.FaultConfig
+	flag.Float64Var(&faults.RequestDrop, "drop-request", 0, "probability of dropping a request (fault injection)")
+	flag.Float64Var(&faults.ReplyDrop, "drop-reply", 0, "probability of dropping a reply (fault injection)")
+	flag.DurationVar(&faults.Delay, "delay", 0, "latency added to every reply (fault injection)")
+	flag.DurationVar(&faults.Jitter, "jitter", 0, "maximum random latency added on top of -delay (fault injection)")
+	flag.Float64Var(&faults.Duplicate, "duplicate", 0, "probability of sending a reply twice (fault injection)")
+	flag.Float64Var(&faults.Reorder, "reorder", 0, "probability of holding a reply back by -reorder-delay (fault injection)")
+	flag.DurationVar(&faults.ReorderDelay, "reorder-delay", 500*time.Millisecond, "latency of reordered replies (fault injection)")
+	flag.Int64Var(&faults.Seed, "seed", 1, "random seed of fault injection")
 
 	flag.Parse()
 
-	database.Init()
-	flight.Init()
-	defer database.Close()
+	if migrateTo != database.Latest {
+		return migrate(database.DSN(dsn), migrateTo)
+	}
+
+	store, err := openStore(storeName, database.DSN(dsn))
+	if err != nil {
+		return fmt.Errorf("failed to open %s store: %v", storeName, err)
+	}
+	flight.Init(store)
+	defer store.Close()
+
+	var cache *rpc.ReplyCache
+	if filterDuplicate {
+		cache, err = rpc.NewReplyCache(cacheSize, cacheTTL)
+		if err != nil {
+			return fmt.Errorf("failed to create reply cache: %v", err)
+		}
+		if cacheStats > 0 {
+			go logCacheStats(cache, cacheStats)
+		}
+	}
+
+	var transports []rpc.ServerTransport
+	listenAddr := fmt.Sprintf(":%d", port)
+	if transportName == "udp" || transportName == "both" {
+		transport, err := rpc.NewServerUDPSocket(listenAddr, cache)
+		if err != nil {
+			return fmt.Errorf("failed to create UDP transport: %v", err)
+		}
+		transports = append(transports, transport)
+	}
+	if transportName == "tcp" || transportName == "both" {
+		transport, err := rpc.NewServerTCPSocket(listenAddr, cache)
+		if err != nil {
+			return fmt.Errorf("failed to create TCP transport: %v", err)
+		}
+		transports = append(transports, transport)
+	}
+	if len(transports) == 0 {
+		return fmt.Errorf("unknown transport %q", transportName)
+	}
+
+	var authenticator rpc.Authenticator
+	if keysPath != "" {
+		keys, err := rpc.LoadKeys(keysPath)
+		if err != nil {
+			return fmt.Errorf("failed to load keys: %v", err)
+		}
+		a := rpc.NewHMACAuthenticator(keys)
+		a.MaxClockSkew = maxClockSkew
+		authenticator = a
+	}
+
+	transportFactory := rpc.NewTransportFactory()
+	if pskPath != "" {
+		key, err := rpc.LoadSecureKey(pskPath)
+		if err != nil {
+			return fmt.Errorf("failed to load pre-shared key: %v", err)
+		}
+		// the secure transport must wrap the accepted transports directly
+		transportFactory, err = rpc.NewSecureTransportFactory(key, replayWindow)
+		if err != nil {
+			return fmt.Errorf("failed to create secure transport: %v", err)
+		}
+	}
+	if faults.Enabled() {
+		transportFactory = rpc.NewFaultTransportFactory(transportFactory, faults)
+	}
 
-	transport, _ := rpc.NewServerUDPSocket(fmt.Sprintf(":%d", port), filterDuplicate)
 	processor := flight.NewProcessor()
-	server := rpc.NewUdpServer(processor,
-		transport,
-		rpc.NewTransportFactory(),
-		rpc.NewBinaryProtocolFactory(),
-	)
 
-	log.Printf("Starting server on port %d\n", port)
+	log.Printf("Starting server on port %d over %s\n", port, transportName)
 	log.Println("Filtering duplicate:", filterDuplicate)
-	server.Serve()
+	log.Println("Storing flights in:", storeName)
+	log.Println("Authenticating requests:", authenticator != nil)
+	log.Println("Encrypting messages:", pskPath != "")
+	if faults.Enabled() {
+		log.Printf("Simulating faults: %+v\n", faults)
+	}
+
+	errs := make(chan error, len(transports))
+	for _, transport := range transports {
+		server := rpc.NewUdpServer(processor,
+			transport,
+			transportFactory,
+			rpc.NewBinaryProtocolFactory(),
+		)
+		if authenticator != nil {
+			server.SetAuthenticator(authenticator)
+		}
+		go func() {
+			errs <- server.Serve()
+		}()
+	}
+	if err := <-errs; err != nil {
+		return fmt.Errorf("server stopped: %v", err)
+	}
+	return nil
+}
+
+func openStore(name, dsn string) (flight.FlightStore, error) {
+	switch name {
+	case "sqlite":
+		db, err := database.Open(dsn)
+		if err != nil {
+			return nil, err
+		}
+		store, err := flight.NewSQLiteStore(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return store, nil
+	case "memory":
+		return flight.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", name)
+	}
+}
+
+// migrate migrates the schema of the sqlite store without serving requests
+func migrate(dsn string, version int) error {
+	db, err := database.Open(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.Migrate(db, flight.Migrations, version); err != nil {
+		return err
+	}
+	log.Println("schema is at version", version)
+	return nil
+}
+
+func logCacheStats(cache *rpc.ReplyCache, interval time.Duration) {
+	for range time.Tick(interval) {
+		stats := cache.Stats()
+		log.Printf("reply cache: size=%d hits=%d misses=%d in-progress=%d evictions=%d expirations=%d\n",
+			stats.Size, stats.Hits, stats.Misses, stats.InProgress, stats.Evictions, stats.Expirations)
+	}
 }
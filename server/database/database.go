@@ -1,25 +1,64 @@
 package database
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite" // sqlite
 )
 
-var (
-	// DB variable shared across module
-	DB *gorm.DB
-)
+// DefaultDSN is the database used if none is given
+const DefaultDSN = "sqlite3://database.sqlite3"
 
-// Init opens DB connection
-func Init() {
-	var err error
-	DB, err = gorm.Open("sqlite3", "database.sqlite3")
-	if err != nil {
-		panic("failed to connect database")
+// DSNEnv is the environment variable read for the DSN if none is given
+const DSNEnv = "FLIGHT_DB_DSN"
+
+// DSN returns dsn if set, otherwise the DSN of the environment or DefaultDSN
+func DSN(dsn string) string {
+	if dsn != "" {
+		return dsn
+	}
+	if dsn := os.Getenv(DSNEnv); dsn != "" {
+		return dsn
+	}
+	return DefaultDSN
+}
+
+// parseDSN splits a DSN of the form driver://source. A DSN without a driver
+// is taken as the path of a sqlite database.
+func parseDSN(dsn string) (driver, source string, err error) {
+	i := strings.Index(dsn, "://")
+	if i < 0 {
+		return "sqlite3", dsn, nil
+	}
+	driver, source = dsn[:i], dsn[i+len("://"):]
+	switch driver {
+	case "sqlite", "sqlite3":
+		driver = "sqlite3"
+	default:
+		return "", "", fmt.Errorf("unsupported database driver %q", driver)
 	}
+	if source == "" {
+		return "", "", fmt.Errorf("missing database in DSN %q", dsn)
+	}
+	return driver, source, nil
 }
 
-// Close closes DB connection
-func Close() {
-	DB.Close()
+// Open connects to the database of dsn, a sqlite database is created if
+// needed
+func Open(dsn string) (*gorm.DB, error) {
+	driver, source, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(driver, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s database %s: %v", driver, source, err)
+	}
+	// sqlite only allows a single writer, serialize access through one
+	// connection instead of failing concurrent transactions with SQLITE_BUSY
+	db.DB().SetMaxOpenConns(1)
+	return db, nil
 }
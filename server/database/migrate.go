@@ -0,0 +1,123 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a versioned change of the schema. Versions start at 1 and
+// are consecutive, Down reverts the change of Up.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Latest is the target version of Migrate applying all migrations
+const Latest = -1
+
+const schemaTable = "schema_migrations"
+
+type schemaMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func ensureSchemaTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS ` + schemaTable + ` (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`).Error
+}
+
+// Version returns the version of the schema of db, 0 if no migration was
+// applied
+func Version(db *gorm.DB) (int, error) {
+	if err := ensureSchemaTable(db); err != nil {
+		return 0, err
+	}
+	var applied []schemaMigration
+	if err := db.Table(schemaTable).Order("version desc").Limit(1).Find(&applied).Error; err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[0].Version, nil
+}
+
+// Migrate applies or reverts migrations until the schema of db is at the
+// target version, or at the last migration if target is Latest. Every
+// migration runs in its own transaction together with its record in the
+// schema table, a failed migration leaves the schema at the version before.
+func Migrate(db *gorm.DB, migrations []Migration, target int) error {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+	}
+	if target == Latest {
+		target = len(migrations)
+	}
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, len(migrations))
+	}
+
+	current, err := Version(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this server, latest is %d", current, len(migrations))
+	}
+
+	for current < target {
+		m := migrations[current]
+		log.Printf("migrating schema up to %d: %s\n", m.Version, m.Name)
+		err := apply(db, m.Up, func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO "+schemaTable+" (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now()).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %q failed: %v", m.Version, m.Name, err)
+		}
+		current++
+	}
+	for current > target {
+		m := migrations[current-1]
+		log.Printf("migrating schema down from %d: %s\n", m.Version, m.Name)
+		if m.Down == nil {
+			return fmt.Errorf("migration %d %q can't be reverted", m.Version, m.Name)
+		}
+		err := apply(db, m.Down, func(tx *gorm.DB) error {
+			return tx.Exec("DELETE FROM "+schemaTable+" WHERE version = ?", m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %q failed: %v", m.Version, m.Name, err)
+		}
+		current--
+	}
+	return nil
+}
+
+func apply(db *gorm.DB, change, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -5,46 +5,152 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/felixputera/cz4013-flight-info/server/rpc"
 )
 
+//go:generate go run ../cmd/rpcgen -o flight_rpc.go flight.idl
+
 type Processor struct {
 	methodMap map[string]rpc.ProcessorFunction
 }
 
 func NewProcessor() *Processor {
-	return &Processor{
-		methodMap: map[string]rpc.ProcessorFunction{
-			"getFlight":        &getFlightProcessor{},
-			"reserve":          &reserveProcessor{},
-			"monitorSeats":     &monitorSeatsProcessor{},
-			"findFlights":      &findFlightsProcessor{},
-			"newFlight":        &newFlightProcessor{},
-			"findDestinations": &findDestinationsProcessor{},
-		},
-	}
+	methodMap := map[string]rpc.ProcessorFunction{
+		"cancelReservation": &cancelReservationProcessor{},
+		"cancelMonitor":     &cancelMonitorProcessor{},
+		"ackMonitor":        &ackMonitorProcessor{},
+		"renewMonitor":      &renewMonitorProcessor{},
+		"listMonitors":      &listMonitorsProcessor{},
+		"searchFlights":     &searchFlightsProcessor{},
+		"updateFlight":      &updateFlightProcessor{},
+		"deleteFlight":      &deleteFlightProcessor{},
+	}
+	// getFlight, reserve, monitorSeats, findFlights, newFlight and
+	// findDestinations
+	addFlightProcessors(methodMap, flightServer{})
+	return &Processor{methodMap: methodMap}
+}
+
+// AdminRole is the role of keys allowed to call admin methods
+const AdminRole = "admin"
+
+// adminMethods change flights. If the server authenticates requests they need
+// a request signed with a key of AdminRole, other methods are public.
+var adminMethods = map[string]bool{
+	"newFlight":    true,
+	"updateFlight": true,
+	"deleteFlight": true,
+}
+
+// authorize checks that the caller may call method, returning the ID of the
+// exception to reply with otherwise
+func authorize(ctx context.Context, method string) (int32, error) {
+	auth := rpc.AuthResultFromContext(ctx)
+	if !adminMethods[method] || auth == nil {
+		return 0, nil
+	}
+	switch {
+	case auth.Err != nil:
+		return rpc.UnauthenticatedID, fmt.Errorf("authentication failed: %v", auth.Err)
+	case auth.Principal == nil:
+		return rpc.UnauthenticatedID, fmt.Errorf("%s requires a signed request", method)
+	case auth.Principal.Role != AdminRole:
+		return rpc.PermissionDeniedID, fmt.Errorf("key %s is not allowed to call %s", auth.Principal.KeyID, method)
+	}
+	return 0, nil
 }
 
 func (p *Processor) Process(ctx context.Context, iprot, oprot rpc.Protocol) (bool, error) {
-	name, _, seqID, err := iprot.ReadMessageBegin()
+	name, typeID, seqID, err := iprot.ReadMessageBegin()
 	if err != nil {
 		return false, err
 	}
 	log.Println("received method", name)
-	if proc, ok := p.methodMap[name]; ok {
-		return proc.Process(ctx, seqID, iprot, oprot)
+	proc, ok := p.methodMap[name]
+	if !ok {
+		// skip the arguments to answer with an exception
+		if err := rpc.Skip(iprot, rpc.Struct); err != nil {
+			return replyProtocolError(oprot, name, seqID, err)
+		}
+		if err := iprot.ReadMessageEnd(); err != nil {
+			return false, err
+		}
+		err := fmt.Errorf("unknown method %s", name)
+		if typeID == rpc.Oneway {
+			return true, err
+		}
+		if e := writeException(oprot, name, seqID, rpc.UnknownMethodID, err.Error()); e != nil {
+			return false, e
+		}
+		return true, err
+	}
+	if typeID, err := authorize(ctx, name); err != nil {
+		// the arguments are left unread, the request is not processed
+		if e := writeException(oprot, name, seqID, typeID, err.Error()); e != nil {
+			return false, e
+		}
+		return true, err
+	}
+	return proc.Process(ctx, seqID, iprot, oprot)
+}
+
+// writeException answers the call of method with an exception
+func writeException(oprot rpc.Protocol, method string, seqID int32, typeID int32, message string) error {
+	return rpc.WriteException(oprot, method, seqID, rpc.NewApplicationException(typeID, message))
+}
+
+// replyProtocolError answers a call whose arguments can't be decoded with a
+// ProtocolErrorID exception
+func replyProtocolError(oprot rpc.Protocol, method string, seqID int32, err error) (bool, error) {
+	return rpc.ReplyException(oprot, method, seqID, rpc.NewArgumentsException(method, err))
+}
+
+// flightServer implements the methods of the flight service with generated
+// processors, see flight.idl
+type flightServer struct{}
+
+// newFlightInfo returns the flight as sent to clients
+func newFlightInfo(f *Flight) *flightInfo {
+	info := &flightInfo{
+		id:             f.ID,
+		from:           f.From,
+		to:             f.To,
+		time:           f.DepartureTime.Format(TimeLayout),
+		availableSeats: f.AvailabeSeats,
+		fare:           f.Fare,
+		departureTime:  TimeToMillis(f.DepartureTime),
+	}
+	if !f.ArrivalTime.IsZero() {
+		info.arrivalTime = TimeToMillis(f.ArrivalTime)
+	}
+	return info
+}
+
+func (flightServer) getFlight(ctx context.Context, client string, args *getFlightArgs) (*getFlightResult, error) {
+	flight, err := GetFlight(args.id)
+	if err != nil {
+		return nil, err
+	}
+	return &getFlightResult{flight: newFlightInfo(flight)}, nil
+}
+
+func (flightServer) reserve(ctx context.Context, client string, args *reserveArgs) (*reserveResult, error) {
+	reservation, err := MakeReservation(args.id, args.seats, client)
+	if err != nil {
+		return nil, err
 	}
-	return false, errors.New("method not found")
+	return &reserveResult{bookingRef: reservation.ID}, nil
 }
 
-type getFlightProcessor struct{}
+type cancelReservationProcessor struct{}
 
-type getFlightArgs struct {
-	id string
+type cancelReservationArgs struct {
+	bookingRef string
 }
 
-func (a *getFlightArgs) read(iprot rpc.Protocol) (err error) {
+func (a *cancelReservationArgs) read(iprot rpc.Protocol) error {
 	for {
 		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
 		if err != nil {
@@ -56,14 +162,19 @@ func (a *getFlightArgs) read(iprot rpc.Protocol) (err error) {
 		switch fieldID {
 		case 1:
 			if fieldType == rpc.String {
-				a.id, err = iprot.ReadString()
+				a.bookingRef, err = iprot.ReadString()
 				if err != nil {
 					return rpc.PrependError("failed reading field 1 content", err)
 				}
 			} else {
 				return errors.New("field 1 is not string type")
 			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
 		}
+
 		if err := iprot.ReadFieldEnd(); err != nil {
 			return err
 		}
@@ -71,134 +182,154 @@ func (a *getFlightArgs) read(iprot rpc.Protocol) (err error) {
 	return nil
 }
 
-type getFlightResult struct {
-	flight *Flight
-}
-
-func (f *Flight) write(oprot rpc.Protocol) (err error) {
-	if err = oprot.WriteFieldBegin("id", rpc.String, 1); err != nil {
-		return
-	}
-	if err = oprot.WriteString(f.ID); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldBegin("from", rpc.String, 2); err != nil {
-		return
-	}
-	if err = oprot.WriteString(f.From); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldBegin("to", rpc.String, 3); err != nil {
-		return
-	}
-	if err = oprot.WriteString(f.To); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldBegin("time", rpc.String, 4); err != nil {
-		return
-	}
-	if err = oprot.WriteString(f.Time); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldBegin("availableSeats", rpc.I32, 5); err != nil {
-		return
+func (p *cancelReservationProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &cancelReservationArgs{}
+	if err := args.read(iprot); err != nil {
+		return replyProtocolError(oprot, "cancelReservation", seqID, err)
 	}
-	if err = oprot.WriteI32(f.AvailabeSeats); err != nil {
-		return
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return false, err
 	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
+
+	_, err := CancelReservation(args.bookingRef)
+	if err != nil {
+		return rpc.ReplyException(oprot, "cancelReservation", seqID, rpc.NewMethodException("cancelReservation", err))
 	}
-	if err = oprot.WriteFieldBegin("fare", rpc.Float, 6); err != nil {
-		return
+
+	res := &voidResult{}
+	if err := oprot.WriteMessageBegin("cancelReservation", rpc.Reply, seqID); err != nil {
+		return false, err
 	}
-	if err = oprot.WriteFloat(f.Fare); err != nil {
-		return
+	if err := res.write(oprot); err != nil {
+		return false, err
 	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
+	if err = oprot.WriteMessageEnd(); err != nil {
+		return false, err
 	}
-	if err = oprot.WriteFieldStop(); err != nil {
-		return
+	return true, oprot.Flush()
+}
+
+func (a *monitorSeatsArgs) query() MonitorQuery {
+	query := MonitorQuery{FlightIDs: a.flightIDs, From: a.from, To: a.to}
+	if a.id != "" {
+		query.FlightIDs = append([]string{a.id}, query.FlightIDs...)
 	}
+	return query
+}
+
+// monitorSeats streams the updates of the subscription to the client, which
+// is identified by the address its callbacks are sent to
+func (flightServer) monitorSeats(ctx context.Context, client string, args *monitorSeatsArgs, stream *monitorSeatsStream) error {
+	duration := time.Duration(args.durationMs) * time.Millisecond
+	monitor, resChan, err := MonitorFlights(args.query(), client, duration, args.reliable)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for update := range resChan {
+			res := &monitorSeatsResult{
+				seats:          update.AvailableSeats,
+				subscriptionID: monitor.ID,
+				// relative to not depend on the clocks of server and client
+				expiresInMs: int32(time.Until(update.ExpiresAt) / time.Millisecond),
+				sequence:    update.Sequence,
+				event:       string(update.Event),
+				flightID:    update.FlightID,
+				fare:        update.Fare,
+			}
+			// departure and arrival are zero for a renewal
+			if !update.DepartureTime.IsZero() {
+				res.departureTime = TimeToMillis(update.DepartureTime)
+			}
+			if !update.ArrivalTime.IsZero() {
+				res.arrivalTime = TimeToMillis(update.ArrivalTime)
+			}
+			if err := stream.send(res); err != nil {
+				log.Println("failed to send update of monitor", monitor.ID, err)
+			}
+		}
+
+		if err := stream.close(errMonitorClosed); err != nil {
+			log.Println("failed to close monitor", monitor.ID, err)
+		}
+	}()
+
 	return nil
 }
 
-func (r *getFlightResult) write(oprot rpc.Protocol) (err error) {
-	if err = oprot.WriteFieldBegin("flight", rpc.Struct, 1); err != nil {
-		return
-	}
-	if err = r.flight.write(oprot); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldStop(); err != nil {
-		return
+type cancelMonitorProcessor struct{}
+
+type cancelMonitorArgs struct {
+	subscriptionID string
+}
+
+func (a *cancelMonitorArgs) read(iprot rpc.Protocol) error {
+	for {
+		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return rpc.PrependError(fmt.Sprintf("%T field %d read error: ", a, fieldID), err)
+		}
+		if fieldType == rpc.Stop {
+			break
+		}
+		switch fieldID {
+		case 1:
+			if fieldType == rpc.String {
+				a.subscriptionID, err = iprot.ReadString()
+				if err != nil {
+					return rpc.PrependError("failed reading field 1 content", err)
+				}
+			} else {
+				return errors.New("field 1 is not string type")
+			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		}
+
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (p *getFlightProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	// Read field arguments
-	args := &getFlightArgs{}
+func (p *cancelMonitorProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &cancelMonitorArgs{}
 	if err := args.read(iprot); err != nil {
-		return false, err
+		return replyProtocolError(oprot, "cancelMonitor", seqID, err)
 	}
 	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
 
-	// process
-	flight, err := GetFlight(args.id)
-
-	if err != nil {
-		// handle exception in method, e.g. flight not found
-		oprot.WriteMessageBegin("getFlight", rpc.Exception, seqID)
-		appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing getFlight: "+err.Error())
-		appErr.Write(oprot)
-		oprot.WriteMessageEnd()
-		oprot.Flush()
-		return true, err
+	if err := CancelMonitor(args.subscriptionID); err != nil {
+		return rpc.ReplyException(oprot, "cancelMonitor", seqID, rpc.NewMethodException("cancelMonitor", err))
 	}
 
-	// success, write output
-	res := &getFlightResult{flight: flight}
-	if err = oprot.WriteMessageBegin("getFlight", rpc.Reply, seqID); err != nil {
+	res := &voidResult{}
+	if err := oprot.WriteMessageBegin("cancelMonitor", rpc.Reply, seqID); err != nil {
 		return false, err
 	}
-	if err = res.write(oprot); err != nil {
+	if err := res.write(oprot); err != nil {
 		return false, err
 	}
-	if err = oprot.WriteMessageEnd(); err != nil {
+	if err := oprot.WriteMessageEnd(); err != nil {
 		return false, err
 	}
-	oprot.Flush()
-
-	return true, nil
+	return true, oprot.Flush()
 }
 
-type reserveProcessor struct{}
+type ackMonitorProcessor struct{}
 
-type reserveArgs struct {
-	id    string
-	seats int32
+type ackMonitorArgs struct {
+	subscriptionID string
+	sequence       int32
 }
 
-func (a *reserveArgs) read(iprot rpc.Protocol) error {
+func (a *ackMonitorArgs) read(iprot rpc.Protocol) error {
 	for {
 		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
 		if err != nil {
@@ -210,7 +341,7 @@ func (a *reserveArgs) read(iprot rpc.Protocol) error {
 		switch fieldID {
 		case 1:
 			if fieldType == rpc.String {
-				a.id, err = iprot.ReadString()
+				a.subscriptionID, err = iprot.ReadString()
 				if err != nil {
 					return rpc.PrependError("failed reading field 1 content", err)
 				}
@@ -219,13 +350,17 @@ func (a *reserveArgs) read(iprot rpc.Protocol) error {
 			}
 		case 2:
 			if fieldType == rpc.I32 {
-				a.seats, err = iprot.ReadI32()
+				a.sequence, err = iprot.ReadI32()
 				if err != nil {
 					return rpc.PrependError("failed reading field 2 content", err)
 				}
 			} else {
 				return errors.New("field 2 is not int32 type")
 			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
 		}
 
 		if err := iprot.ReadFieldEnd(); err != nil {
@@ -235,8 +370,10 @@ func (a *reserveArgs) read(iprot rpc.Protocol) error {
 	return nil
 }
 
-func (p *reserveProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	args := &reserveArgs{}
+// Process handles a one-way acknowledgement of monitor updates, nothing is
+// sent back
+func (p *ackMonitorProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &ackMonitorArgs{}
 	if err := args.read(iprot); err != nil {
 		return false, err
 	}
@@ -244,42 +381,20 @@ func (p *reserveProcessor) Process(ctx context.Context, seqID int32, iprot, opro
 		return false, err
 	}
 
-	err := MakeReservation(args.id, args.seats)
-	if err != nil {
-		oprot.WriteMessageBegin("reserve", rpc.Exception, seqID)
-		appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing reserve: "+err.Error())
-		appErr.Write(oprot)
-		err = oprot.WriteMessageEnd()
-		if err != nil {
-			return false, err
-		}
-		oprot.Flush()
-		return true, err
-	}
-
-	res := &voidResult{}
-	if err := oprot.WriteMessageBegin("reserve", rpc.Reply, seqID); err != nil {
-		return false, err
-	}
-	if err := res.write(oprot); err != nil {
-		return false, err
+	if err := AckMonitor(args.subscriptionID, args.sequence); err != nil {
+		log.Println("ignoring acknowledgement:", err)
 	}
-	if err = oprot.WriteMessageEnd(); err != nil {
-		return false, err
-	}
-	oprot.Flush()
-
 	return true, nil
 }
 
-type monitorSeatsProcessor struct{}
+type renewMonitorProcessor struct{}
 
-type monitorSeatsArgs struct {
-	id         string
-	durationMs int32
+type renewMonitorArgs struct {
+	subscriptionID string
+	durationMs     int32
 }
 
-func (a *monitorSeatsArgs) read(iprot rpc.Protocol) error {
+func (a *renewMonitorArgs) read(iprot rpc.Protocol) error {
 	for {
 		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
 		if err != nil {
@@ -291,7 +406,7 @@ func (a *monitorSeatsArgs) read(iprot rpc.Protocol) error {
 		switch fieldID {
 		case 1:
 			if fieldType == rpc.String {
-				a.id, err = iprot.ReadString()
+				a.subscriptionID, err = iprot.ReadString()
 				if err != nil {
 					return rpc.PrependError("failed reading field 1 content", err)
 				}
@@ -307,6 +422,10 @@ func (a *monitorSeatsArgs) read(iprot rpc.Protocol) error {
 			} else {
 				return errors.New("field 2 is not int32 type")
 			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
 		}
 
 		if err := iprot.ReadFieldEnd(); err != nil {
@@ -316,15 +435,15 @@ func (a *monitorSeatsArgs) read(iprot rpc.Protocol) error {
 	return nil
 }
 
-type monitorSeatsResult struct {
-	seats int32
+type renewMonitorResult struct {
+	expiresAt time.Time
 }
 
-func (r *monitorSeatsResult) write(oprot rpc.Protocol) (err error) {
-	if err = oprot.WriteFieldBegin("seats", rpc.I32, 1); err != nil {
+func (r *renewMonitorResult) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("expiresAt", rpc.I64, 1); err != nil {
 		return
 	}
-	if err = oprot.WriteI32(r.seats); err != nil {
+	if err = oprot.WriteI64(TimeToMillis(r.expiresAt)); err != nil {
 		return
 	}
 	if err = oprot.WriteFieldEnd(); err != nil {
@@ -336,74 +455,131 @@ func (r *monitorSeatsResult) write(oprot rpc.Protocol) (err error) {
 	return nil
 }
 
-func (p *monitorSeatsProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	args := &monitorSeatsArgs{}
+func (p *renewMonitorProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &renewMonitorArgs{}
 	if err := args.read(iprot); err != nil {
+		return replyProtocolError(oprot, "renewMonitor", seqID, err)
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return false, err
+	}
+
+	duration := time.Duration(args.durationMs) * time.Millisecond
+	monitor, err := RenewMonitor(args.subscriptionID, duration)
+	if err != nil {
+		return rpc.ReplyException(oprot, "renewMonitor", seqID, rpc.NewMethodException("renewMonitor", err))
+	}
+
+	res := &renewMonitorResult{expiresAt: monitor.ExpiresAt}
+	if err := oprot.WriteMessageBegin("renewMonitor", rpc.Reply, seqID); err != nil {
+		return false, err
+	}
+	if err := res.write(oprot); err != nil {
 		return false, err
 	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return false, err
+	}
+	return true, oprot.Flush()
+}
+
+type listMonitorsProcessor struct{}
+
+// monitorInfo is a monitor as listed to its client
+type monitorInfo struct {
+	SubscriptionID string    `rpc:"1,string"`
+	FlightIDs      []string  `rpc:"2,list"`
+	ExpiresAt      time.Time `rpc:"3,i64"`
+	From           string    `rpc:"4,string"`
+	To             string    `rpc:"5,string"`
+}
+
+type listMonitorsResult struct {
+	Monitors []*monitorInfo `rpc:"1,list"`
+}
+
+func newListMonitorsResult(monitors []*Monitor) *listMonitorsResult {
+	res := &listMonitorsResult{Monitors: make([]*monitorInfo, 0, len(monitors))}
+	for _, m := range monitors {
+		res.Monitors = append(res.Monitors, &monitorInfo{
+			SubscriptionID: m.ID,
+			FlightIDs:      m.Query.FlightIDs,
+			ExpiresAt:      m.ExpiresAt,
+			From:           m.Query.From,
+			To:             m.Query.To,
+		})
+	}
+	return res
+}
+
+func (p *listMonitorsProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	// no arguments, any sent are skipped
+	if err := rpc.Skip(iprot, rpc.Struct); err != nil {
+		return replyProtocolError(oprot, "listMonitors", seqID, err)
+	}
 	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
 
-	resChan, errChan := MonitorAvailableSeats(args.id, args.durationMs)
+	res := newListMonitorsResult(ListMonitors(rpc.RemoteAddress(oprot)))
+	if err := oprot.WriteMessageBegin("listMonitors", rpc.Reply, seqID); err != nil {
+		return false, err
+	}
+	if err := rpc.Marshal(oprot, res); err != nil {
+		return false, err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return false, err
+	}
+	return true, oprot.Flush()
+}
 
-	go func(seqID int32) {
-		for {
-			if resChan == nil && errChan == nil {
-				oprot.WriteMessageBegin("reserve", rpc.Exception, seqID)
-				appErr := rpc.NewApplicationException(rpc.InternalErrorID, "closing")
-				appErr.Write(oprot)
-				oprot.WriteMessageEnd()
-				oprot.Flush()
-				break
-			}
+// departure returns the departure time, preferring the epoch millis field
+// over the formatted string sent by older clients
+func (a *newFlightArgs) departure() (time.Time, error) {
+	if a.departureTime != 0 {
+		return MillisToTime(a.departureTime), nil
+	}
+	if a.time == "" {
+		return time.Time{}, nil
+	}
+	return ParseTime(a.time)
+}
 
-			select {
-			case seats, ok := <-resChan:
-				if !ok {
-					resChan = nil
-					continue
-				}
-				res := &monitorSeatsResult{seats: seats}
-				if err := oprot.WriteMessageBegin("reserve", rpc.Reply, seqID); err != nil {
-					panic(err)
-				}
-				if err := res.write(oprot); err != nil {
-					panic(err)
-				}
-				if err := oprot.WriteMessageEnd(); err != nil {
-					panic(err)
-				}
-				oprot.Flush()
-			case err, ok := <-errChan:
-				if !ok {
-					errChan = nil
-					continue
-				}
-				oprot.WriteMessageBegin("reserve", rpc.Exception, seqID)
-				appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing getFlight: "+err.Error())
-				appErr.Write(oprot)
-				oprot.WriteMessageEnd()
-				oprot.Flush()
-			}
-		}
-	}(seqID)
+func (a *newFlightArgs) arrival() time.Time {
+	if a.arrivalTime == 0 {
+		return time.Time{}
+	}
+	return MillisToTime(a.arrivalTime)
+}
 
-	return true, nil
+func (flightServer) newFlight(ctx context.Context, client string, args *newFlightArgs) error {
+	departureTime, err := args.departure()
+	if err != nil {
+		return err
+	}
+	_, err = NewFlight(args.id, args.from, args.to, departureTime, args.arrival(), args.availableSeats, args.fare)
+	return err
+}
+
+type voidResult struct{}
+
+func (r *voidResult) write(oprot rpc.Protocol) error {
+	return oprot.WriteFieldStop()
 }
 
-type newFlightProcessor struct{}
+type updateFlightProcessor struct{}
 
-type newFlightArgs struct {
+// updateFlightArgs holds the fields to change, unset fields are nil
+type updateFlightArgs struct {
 	id             string
-	from           string
-	to             string
-	time           string
-	availableSeats int32
-	fare           float32
+	departureTime  *int64
+	arrivalTime    *int64
+	availableSeats *int32
+	fare           *float32
 }
 
-func (a *newFlightArgs) read(iprot rpc.Protocol) error {
+func (a *updateFlightArgs) read(iprot rpc.Protocol) error {
 	for {
 		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
 		if err != nil {
@@ -423,49 +599,48 @@ func (a *newFlightArgs) read(iprot rpc.Protocol) error {
 				return errors.New("field 1 is not string type")
 			}
 		case 2:
-			if fieldType == rpc.String {
-				a.from, err = iprot.ReadString()
+			if fieldType == rpc.I64 {
+				ms, err := iprot.ReadI64()
 				if err != nil {
 					return rpc.PrependError("failed reading field 2 content", err)
 				}
+				a.departureTime = &ms
 			} else {
-				return errors.New("field 2 is not string type")
+				return errors.New("field 2 is not int64 type")
 			}
 		case 3:
-			if fieldType == rpc.String {
-				a.to, err = iprot.ReadString()
+			if fieldType == rpc.I64 {
+				ms, err := iprot.ReadI64()
 				if err != nil {
 					return rpc.PrependError("failed reading field 3 content", err)
 				}
+				a.arrivalTime = &ms
 			} else {
-				return errors.New("field 3 is not string type")
+				return errors.New("field 3 is not int64 type")
 			}
 		case 4:
-			if fieldType == rpc.String {
-				a.time, err = iprot.ReadString()
+			if fieldType == rpc.I32 {
+				seats, err := iprot.ReadI32()
 				if err != nil {
 					return rpc.PrependError("failed reading field 4 content", err)
 				}
+				a.availableSeats = &seats
 			} else {
-				return errors.New("field 4 is not string type")
+				return errors.New("field 4 is not int32 type")
 			}
 		case 5:
-			if fieldType == rpc.I32 {
-				a.availableSeats, err = iprot.ReadI32()
+			if fieldType == rpc.Float {
+				fare, err := iprot.ReadFloat()
 				if err != nil {
 					return rpc.PrependError("failed reading field 5 content", err)
 				}
+				a.fare = &fare
 			} else {
-				return errors.New("field 5 is not i32 type")
+				return errors.New("field 5 is not float type")
 			}
-		case 6:
-			if fieldType == rpc.Float {
-				a.fare, err = iprot.ReadFloat()
-				if err != nil {
-					return rpc.PrependError("failed reading field 6 content", err)
-				}
-			} else {
-				return errors.New("field 6 is not float type")
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
 			}
 		}
 
@@ -476,156 +651,112 @@ func (a *newFlightArgs) read(iprot rpc.Protocol) error {
 	return nil
 }
 
-type voidResult struct{}
-
-func (r *voidResult) write(oprot rpc.Protocol) error {
-	return oprot.WriteFieldStop()
+// update converts the epoch millis times, an arrival time of 0 makes the
+// arrival time unknown
+func (a *updateFlightArgs) update() *FlightUpdate {
+	update := &FlightUpdate{AvailableSeats: a.availableSeats, Fare: a.fare}
+	if a.departureTime != nil {
+		departure := MillisToTime(*a.departureTime)
+		update.DepartureTime = &departure
+	}
+	if a.arrivalTime != nil {
+		var arrival time.Time
+		if *a.arrivalTime != 0 {
+			arrival = MillisToTime(*a.arrivalTime)
+		}
+		update.ArrivalTime = &arrival
+	}
+	return update
 }
 
-func (p *newFlightProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	args := &newFlightArgs{}
+func (p *updateFlightProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &updateFlightArgs{}
 	if err := args.read(iprot); err != nil {
-		return false, err
+		return replyProtocolError(oprot, "updateFlight", seqID, err)
 	}
 	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
 
-	_, err := NewFlight(args.id, args.from, args.to, args.time, args.availableSeats, args.fare)
+	flight, err := UpdateFlight(args.id, args.update())
 	if err != nil {
-		oprot.WriteMessageBegin("newFlight", rpc.Exception, seqID)
-		appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing reserve: "+err.Error())
-		appErr.Write(oprot)
-		err = oprot.WriteMessageEnd()
-		if err != nil {
-			return false, err
-		}
-		oprot.Flush()
-		return true, err
+		return rpc.ReplyException(oprot, "updateFlight", seqID, rpc.NewMethodException("updateFlight", err))
 	}
 
-	res := &voidResult{}
-	if err := oprot.WriteMessageBegin("newFlight", rpc.Reply, seqID); err != nil {
+	res := &getFlightResult{flight: newFlightInfo(flight)}
+	if err := oprot.WriteMessageBegin("updateFlight", rpc.Reply, seqID); err != nil {
 		return false, err
 	}
 	if err := res.write(oprot); err != nil {
 		return false, err
 	}
-	if err = oprot.WriteMessageEnd(); err != nil {
+	if err := oprot.WriteMessageEnd(); err != nil {
 		return false, err
 	}
-	oprot.Flush()
-
-	return true, nil
+	return true, oprot.Flush()
 }
 
-type findDestinationsProcessor struct{}
-
-type findDestinationsArgs struct {
-	from string
-}
+type deleteFlightProcessor struct{}
 
-func (a *findDestinationsArgs) read(iprot rpc.Protocol) error {
-	for {
-		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
-		if err != nil {
-			return rpc.PrependError(fmt.Sprintf("%T field %d read error: ", a, fieldID), err)
-		}
-		if fieldType == rpc.Stop {
-			break
-		}
-		switch fieldID {
-		case 1:
-			if fieldType == rpc.String {
-				a.from, err = iprot.ReadString()
-				if err != nil {
-					return rpc.PrependError("failed reading field 1 content", err)
-				}
-			} else {
-				return errors.New("field 1 is not string type")
-			}
-		}
-
-		if err := iprot.ReadFieldEnd(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-type findDestinationsResult struct {
-	destinations []string
-}
-
-func (r *findDestinationsResult) write(oprot rpc.Protocol) (err error) {
-	if err = oprot.WriteFieldBegin("destinations", rpc.List, 1); err != nil {
-		return
-	}
-	if err = oprot.WriteListBegin(rpc.String, len(r.destinations)); err != nil {
-		return
-	}
-	for _, destination := range r.destinations {
-		if err = oprot.WriteString(destination); err != nil {
-			return
-		}
-	}
-	if err = oprot.WriteListEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldEnd(); err != nil {
-		return
-	}
-	if err = oprot.WriteFieldStop(); err != nil {
-		return
-	}
-	return nil
-}
-
-func (p *findDestinationsProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	args := &findDestinationsArgs{}
+func (p *deleteFlightProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	// same arguments as getFlight
+	args := &getFlightArgs{}
 	if err := args.read(iprot); err != nil {
-		return false, err
+		return replyProtocolError(oprot, "deleteFlight", seqID, err)
 	}
 	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
 
-	destinations, err := FindDestinationsFrom(args.from)
-	if err != nil {
-		oprot.WriteMessageBegin("findDestinationss", rpc.Exception, seqID)
-		appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing reserve: "+err.Error())
-		appErr.Write(oprot)
-		err = oprot.WriteMessageEnd()
-		if err != nil {
-			return false, err
-		}
-		oprot.Flush()
-		return true, err
+	if err := DeleteFlight(args.id); err != nil {
+		return rpc.ReplyException(oprot, "deleteFlight", seqID, rpc.NewMethodException("deleteFlight", err))
 	}
 
-	res := &findDestinationsResult{destinations: destinations}
-	if err = oprot.WriteMessageBegin("findDestinationss", rpc.Reply, seqID); err != nil {
+	res := &voidResult{}
+	if err := oprot.WriteMessageBegin("deleteFlight", rpc.Reply, seqID); err != nil {
 		return false, err
 	}
-	if err = res.write(oprot); err != nil {
+	if err := res.write(oprot); err != nil {
 		return false, err
 	}
-	if err = oprot.WriteMessageEnd(); err != nil {
+	if err := oprot.WriteMessageEnd(); err != nil {
 		return false, err
 	}
-	oprot.Flush()
+	return true, oprot.Flush()
+}
 
-	return true, nil
+func (flightServer) findDestinations(ctx context.Context, client string, args *findDestinationsArgs) (*findDestinationsResult, error) {
+	destinations, err := FindDestinationsFrom(args.from)
+	if err != nil {
+		return nil, err
+	}
+	return &findDestinationsResult{destinations: destinations}, nil
 }
 
-type findFlightsProcessor struct{}
+func (flightServer) findFlights(ctx context.Context, client string, args *findFlightsArgs) (*findFlightsResult, error) {
+	flightIDs, err := FindFlightIDsFromTo(args.from, args.to)
+	if err != nil {
+		return nil, err
+	}
+	return &findFlightsResult{flightIDs: flightIDs}, nil
+}
 
-type findFlightsArgs struct {
-	from string
-	to   string
+type searchFlightsProcessor struct{}
+
+type searchFlightsArgs struct {
+	from         string
+	to           string
+	departAfter  int64
+	departBefore int64
+	maxFare      float32
+	minSeats     int32
+	sortBy       string
+	descending   bool
+	limit        int32
+	offset       int32
 }
 
-func (a *findFlightsArgs) read(iprot rpc.Protocol) error {
+func (a *searchFlightsArgs) read(iprot rpc.Protocol) error {
 	for {
 		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
 		if err != nil {
@@ -653,6 +784,82 @@ func (a *findFlightsArgs) read(iprot rpc.Protocol) error {
 			} else {
 				return errors.New("field 2 is not string type")
 			}
+		case 3:
+			if fieldType == rpc.I64 {
+				a.departAfter, err = iprot.ReadI64()
+				if err != nil {
+					return rpc.PrependError("failed reading field 3 content", err)
+				}
+			} else {
+				return errors.New("field 3 is not i64 type")
+			}
+		case 4:
+			if fieldType == rpc.I64 {
+				a.departBefore, err = iprot.ReadI64()
+				if err != nil {
+					return rpc.PrependError("failed reading field 4 content", err)
+				}
+			} else {
+				return errors.New("field 4 is not i64 type")
+			}
+		case 5:
+			if fieldType == rpc.Float {
+				a.maxFare, err = iprot.ReadFloat()
+				if err != nil {
+					return rpc.PrependError("failed reading field 5 content", err)
+				}
+			} else {
+				return errors.New("field 5 is not float type")
+			}
+		case 6:
+			if fieldType == rpc.I32 {
+				a.minSeats, err = iprot.ReadI32()
+				if err != nil {
+					return rpc.PrependError("failed reading field 6 content", err)
+				}
+			} else {
+				return errors.New("field 6 is not i32 type")
+			}
+		case 7:
+			if fieldType == rpc.String {
+				a.sortBy, err = iprot.ReadString()
+				if err != nil {
+					return rpc.PrependError("failed reading field 7 content", err)
+				}
+			} else {
+				return errors.New("field 7 is not string type")
+			}
+		case 8:
+			if fieldType == rpc.Bool {
+				a.descending, err = iprot.ReadBool()
+				if err != nil {
+					return rpc.PrependError("failed reading field 8 content", err)
+				}
+			} else {
+				return errors.New("field 8 is not bool type")
+			}
+		case 9:
+			if fieldType == rpc.I32 {
+				a.limit, err = iprot.ReadI32()
+				if err != nil {
+					return rpc.PrependError("failed reading field 9 content", err)
+				}
+			} else {
+				return errors.New("field 9 is not i32 type")
+			}
+		case 10:
+			if fieldType == rpc.I32 {
+				a.offset, err = iprot.ReadI32()
+				if err != nil {
+					return rpc.PrependError("failed reading field 10 content", err)
+				}
+			} else {
+				return errors.New("field 10 is not i32 type")
+			}
+		default:
+			if err := rpc.Skip(iprot, fieldType); err != nil {
+				return err
+			}
 		}
 
 		if err := iprot.ReadFieldEnd(); err != nil {
@@ -662,19 +869,39 @@ func (a *findFlightsArgs) read(iprot rpc.Protocol) error {
 	return nil
 }
 
-type findFlightsResult struct {
-	flightIDs []string
+func (a *searchFlightsArgs) query() SearchQuery {
+	q := SearchQuery{
+		From:       a.from,
+		To:         a.to,
+		MaxFare:    a.maxFare,
+		MinSeats:   a.minSeats,
+		SortBy:     a.sortBy,
+		Descending: a.descending,
+		Limit:      a.limit,
+		Offset:     a.offset,
+	}
+	if a.departAfter != 0 {
+		q.DepartAfter = MillisToTime(a.departAfter)
+	}
+	if a.departBefore != 0 {
+		q.DepartBefore = MillisToTime(a.departBefore)
+	}
+	return q
+}
+
+type searchFlightsResult struct {
+	flights []*Flight
 }
 
-func (r *findFlightsResult) write(oprot rpc.Protocol) (err error) {
-	if err = oprot.WriteFieldBegin("flightIDs", rpc.List, 1); err != nil {
+func (r *searchFlightsResult) write(oprot rpc.Protocol) (err error) {
+	if err = oprot.WriteFieldBegin("flights", rpc.List, 1); err != nil {
 		return
 	}
-	if err = oprot.WriteListBegin(rpc.String, len(r.flightIDs)); err != nil {
+	if err = oprot.WriteListBegin(rpc.Struct, len(r.flights)); err != nil {
 		return
 	}
-	for _, flightID := range r.flightIDs {
-		if err = oprot.WriteString(flightID); err != nil {
+	for _, flight := range r.flights {
+		if err = newFlightInfo(flight).write(oprot); err != nil {
 			return
 		}
 	}
@@ -690,30 +917,22 @@ func (r *findFlightsResult) write(oprot rpc.Protocol) (err error) {
 	return nil
 }
 
-func (p *findFlightsProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
-	args := &findFlightsArgs{}
+func (p *searchFlightsProcessor) Process(ctx context.Context, seqID int32, iprot, oprot rpc.Protocol) (bool, error) {
+	args := &searchFlightsArgs{}
 	if err := args.read(iprot); err != nil {
-		return false, err
+		return replyProtocolError(oprot, "searchFlights", seqID, err)
 	}
 	if err := iprot.ReadMessageEnd(); err != nil {
 		return false, err
 	}
 
-	flightIDs, err := FindFlightIDsFromTo(args.from, args.to)
+	flights, err := SearchFlights(args.query())
 	if err != nil {
-		oprot.WriteMessageBegin("findFlights", rpc.Exception, seqID)
-		appErr := rpc.NewApplicationException(rpc.InternalErrorID, "internal server error processing reserve: "+err.Error())
-		appErr.Write(oprot)
-		err = oprot.WriteMessageEnd()
-		if err != nil {
-			return false, err
-		}
-		oprot.Flush()
-		return true, err
+		return rpc.ReplyException(oprot, "searchFlights", seqID, rpc.NewMethodException("searchFlights", err))
 	}
 
-	res := &findFlightsResult{flightIDs: flightIDs}
-	if err = oprot.WriteMessageBegin("findFlights", rpc.Reply, seqID); err != nil {
+	res := &searchFlightsResult{flights: flights}
+	if err = oprot.WriteMessageBegin("searchFlights", rpc.Reply, seqID); err != nil {
 		return false, err
 	}
 	if err = res.write(oprot); err != nil {
@@ -722,7 +941,5 @@ func (p *findFlightsProcessor) Process(ctx context.Context, seqID int32, iprot,
 	if err = oprot.WriteMessageEnd(); err != nil {
 		return false, err
 	}
-	oprot.Flush()
-
-	return true, nil
+	return true, oprot.Flush()
 }
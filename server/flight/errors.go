@@ -0,0 +1,70 @@
+package flight
+
+import (
+	"fmt"
+
+	"github.com/felixputera/cz4013-flight-info/server/rpc"
+)
+
+// Codes of the exceptions replying to calls failing with an Error. They
+// follow the IDs of the rpc application exceptions, any other error is
+// replied to with rpc.InternalErrorID.
+const (
+	FlightNotFoundID int32 = 100 + iota
+	InsufficientSeatsID
+	DuplicateFlightID
+	InvalidArgumentID
+	ReservationNotFoundID
+	FlightReservedID
+	MonitorNotFoundID
+	TooManyMonitorsID
+	// MonitorClosedID ends the updates of a monitor subscription
+	MonitorClosedID
+)
+
+// Error is a failure of a flight operation the caller can react to, its code
+// is sent to clients in the exception reply
+type Error struct {
+	Code    int32
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// TypeID returns the code, making Error an rpc.CodedError
+func (e *Error) TypeID() int32 {
+	return e.Code
+}
+
+// Errors of monitor subscriptions
+var (
+	ErrMonitorNotFound       = &Error{MonitorNotFoundID, "monitor not found"}
+	ErrTooManyMonitors       = &Error{TooManyMonitorsID, "too many active monitors"}
+	ErrTooManyClientMonitors = &Error{TooManyMonitorsID, "too many active monitors for client"}
+
+	// errMonitorClosed ends the updates of a subscription
+	errMonitorClosed = &Error{MonitorClosedID, "closing"}
+)
+
+func invalidArgument(format string, args ...interface{}) error {
+	return &Error{InvalidArgumentID, fmt.Sprintf(format, args...)}
+}
+
+// prefixError prepends context to the message of err, keeping its code
+func prefixError(prefix string, err error) error {
+	if e, ok := err.(*Error); ok {
+		return &Error{e.Code, prefix + ": " + e.Message}
+	}
+	return fmt.Errorf("%s: %v", prefix, err)
+}
+
+// ErrorCode returns the code of the exception replying to a call failing
+// with err
+func ErrorCode(err error) int32 {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return rpc.InternalErrorID
+}
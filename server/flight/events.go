@@ -0,0 +1,123 @@
+package flight
+
+import "sync"
+
+type changeKind int
+
+const (
+	flightAdded changeKind = iota
+	flightChanged
+	flightRemoved
+)
+
+type flightChange struct {
+	kind   changeKind
+	flight Flight
+}
+
+// changeSubscriber receives the changes of the flights it matches. Only the
+// latest change of every flight is kept until the subscriber takes them, so a
+// slow subscriber gets the most recent state instead of every intermediate one
+// and publishers never wait for it.
+type changeSubscriber struct {
+	match func(*Flight) bool
+
+	mu      sync.Mutex
+	pending map[string]*flightChange
+	order   []string
+	notify  chan struct{}
+}
+
+func (s *changeSubscriber) put(change *flightChange) {
+	s.mu.Lock()
+	if prev, ok := s.pending[change.flight.ID]; ok {
+		// a flight added and changed before being taken is still new
+		if prev.kind == flightAdded && change.kind == flightChanged {
+			change.kind = flightAdded
+		}
+	} else {
+		s.order = append(s.order, change.flight.ID)
+	}
+	s.pending[change.flight.ID] = change
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending changes in the order the flights first changed
+func (s *changeSubscriber) take() []*flightChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := make([]*flightChange, 0, len(s.order))
+	for _, id := range s.order {
+		changes = append(changes, s.pending[id])
+	}
+	s.pending = make(map[string]*flightChange)
+	s.order = nil
+	return changes
+}
+
+// changeBus notifies subscribers of flights being added, changed and removed
+type changeBus struct {
+	mu          sync.Mutex
+	subscribers map[*changeSubscriber]bool
+}
+
+var flightChanges = &changeBus{subscribers: make(map[*changeSubscriber]bool)}
+
+// subscribe registers for the changes of flights matched by match, until
+// unsubscribe is called. A value on the notify channel of the subscriber
+// signals pending changes.
+func (b *changeBus) subscribe(match func(*Flight) bool) (*changeSubscriber, func()) {
+	s := &changeSubscriber{
+		match:   match,
+		pending: make(map[string]*flightChange),
+		notify:  make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = true
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		delete(b.subscribers, s)
+		b.mu.Unlock()
+	}
+	return s, unsubscribe
+}
+
+// publish notifies the subscribers matching a flight of its current state. It
+// must be called after the change is committed. The flight is only read if
+// anyone is subscribed, and is read under the lock so subscribers never see
+// an older state after a newer one.
+func (b *changeBus) publish(kind changeKind, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) == 0 {
+		return
+	}
+	flight, err := GetFlight(id)
+	if err != nil {
+		return
+	}
+	b.put(kind, flight)
+}
+
+// publishRemoved notifies the subscribers matching a removed flight
+func (b *changeBus) publishRemoved(flight *Flight) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.put(flightRemoved, flight)
+}
+
+func (b *changeBus) put(kind changeKind, flight *Flight) {
+	for s := range b.subscribers {
+		if s.match(flight) {
+			s.put(&flightChange{kind: kind, flight: *flight})
+		}
+	}
+}
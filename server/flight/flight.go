@@ -1,33 +1,96 @@
 package flight
 
 import (
-	"errors"
+	"crypto/rand"
+	"sort"
 	"time"
-
-	"github.com/felixputera/cz4013-flight-info/server/database"
 )
 
 // Flight type
 type Flight struct {
-	ID            string `gorm:"primary_key"`
-	From          string `gorm:"index"`
-	To            string `gorm:"index"`
-	Time          string
+	ID            string    `gorm:"primary_key"`
+	From          string    `gorm:"index"`
+	To            string    `gorm:"index"`
+	DepartureTime time.Time `gorm:"index"`
+	ArrivalTime   time.Time // zero if unknown
 	AvailabeSeats int32
 	Fare          float32
 }
 
-func Init() {
-	database.DB.AutoMigrate(&Flight{})
+// TimeLayout is the layout used when a flight time is sent as a formatted string
+const TimeLayout = "2006-01-02 15:04 -07:00"
+
+// timeLayouts are the accepted layouts of a formatted flight time, times
+// without an offset are taken as UTC
+var timeLayouts = []string{
+	TimeLayout,
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04 -0700",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// ParseTime parses a formatted flight time in any of the accepted layouts
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, invalidArgument("invalid time %q, expected format like %q", value, TimeLayout)
+}
+
+// TimeToMillis converts a time to milliseconds since the unix epoch
+func TimeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// MillisToTime converts milliseconds since the unix epoch to a UTC time
+func MillisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// Validate checks that the flight is complete and consistent
+func (f *Flight) Validate() error {
+	switch {
+	case f.ID == "":
+		return invalidArgument("flight number is required")
+	case f.From == "" || f.To == "":
+		return invalidArgument("source and destination are required")
+	case f.DepartureTime.IsZero():
+		return invalidArgument("departure time is required")
+	case !f.ArrivalTime.IsZero() && !f.ArrivalTime.After(f.DepartureTime):
+		return invalidArgument("arrival time must be after departure time")
+	case f.AvailabeSeats < 0:
+		return invalidArgument("available seats must not be negative")
+	case f.Fare < 0:
+		return invalidArgument("fare must not be negative")
+	}
+	return nil
+}
+
+// Reservation records the seats booked on a flight by a single reserve call
+type Reservation struct {
+	ID        string `gorm:"primary_key"`
+	FlightID  string `gorm:"index"`
+	Seats     int32
+	Requester string
+	CreatedAt time.Time
+
+	Cancelled   bool
+	CancelledAt *time.Time
 }
 
 func FindFlightIDsFromTo(from, to string) ([]string, error) {
-	var flights []*Flight
 	var flightIDs []string
 
-	database.DB.Find(&flights, Flight{From: from, To: to})
+	flights, err := store.FindFlights(&SearchQuery{From: from, To: to})
+	if err != nil {
+		return flightIDs, err
+	}
 	if len(flights) == 0 {
-		return flightIDs, errors.New("no flight found")
+		return flightIDs, &Error{FlightNotFoundID, "no flight found"}
 	}
 
 	for _, flight := range flights {
@@ -37,103 +100,275 @@ func FindFlightIDsFromTo(from, to string) ([]string, error) {
 	return flightIDs, nil
 }
 
+const (
+	// DefaultSearchLimit is the number of flights returned by a search without limit
+	DefaultSearchLimit = 10
+	// MaxSearchLimit caps the number of flights returned by a single search
+	MaxSearchLimit = 100
+)
+
+// SearchQuery holds the filters of a flight search, zero valued filters are not applied
+type SearchQuery struct {
+	From         string
+	To           string
+	DepartAfter  time.Time
+	DepartBefore time.Time
+	MaxFare      float32
+	MinSeats     int32
+
+	// SortBy is one of "departure" (default), "fare" or "seats"
+	SortBy     string
+	Descending bool
+	Limit      int32
+	Offset     int32
+}
+
+var searchSortKeys = map[string]func(a, b *Flight) bool{
+	"departure": func(a, b *Flight) bool { return a.DepartureTime.Before(b.DepartureTime) },
+	"fare":      func(a, b *Flight) bool { return a.Fare < b.Fare },
+	"seats":     func(a, b *Flight) bool { return a.AvailabeSeats < b.AvailabeSeats },
+}
+
+func (q *SearchQuery) match(f *Flight) bool {
+	if q.From != "" && f.From != q.From {
+		return false
+	}
+	if q.To != "" && f.To != q.To {
+		return false
+	}
+	if !q.DepartAfter.IsZero() && f.DepartureTime.Before(q.DepartAfter) {
+		return false
+	}
+	if !q.DepartBefore.IsZero() && !f.DepartureTime.Before(q.DepartBefore) {
+		return false
+	}
+	if q.MaxFare > 0 && f.Fare > q.MaxFare {
+		return false
+	}
+	if q.MinSeats > 0 && f.AvailabeSeats < q.MinSeats {
+		return false
+	}
+	return true
+}
+
+// SearchFlights returns the flights matching the query, sorted and paginated.
+// An empty result is not an error.
+func SearchFlights(q SearchQuery) ([]*Flight, error) {
+	if q.SortBy == "" {
+		q.SortBy = "departure"
+	}
+	less, ok := searchSortKeys[q.SortBy]
+	if !ok {
+		return nil, invalidArgument("invalid sort order %q", q.SortBy)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return nil, invalidArgument("limit and offset must not be negative")
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+
+	flights, err := store.FindFlights(&q)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(flights, func(i, j int) bool {
+		if q.Descending {
+			return less(flights[j], flights[i])
+		}
+		return less(flights[i], flights[j])
+	})
+
+	if int(q.Offset) >= len(flights) {
+		return []*Flight{}, nil
+	}
+	flights = flights[q.Offset:]
+	if int(q.Limit) < len(flights) {
+		flights = flights[:q.Limit]
+	}
+	return flights, nil
+}
+
 func GetFlight(id string) (*Flight, error) {
 	if id == "" {
-		return nil, errors.New("flight not found")
+		return nil, ErrFlightNotFound
 	}
-	var flight *Flight
-	flight = new(Flight)
-	database.DB.Find(flight, Flight{ID: id})
-	if *flight == (Flight{}) {
-		return nil, errors.New("flight not found")
+	return store.GetFlight(id)
+}
+
+// MakeReservation makes flight reservation and reduce the number of available seats.
+// The returned reservation carries the booking reference given back to the requester.
+func MakeReservation(id string, seats int32, requester string) (*Reservation, error) {
+	if seats <= 0 {
+		return nil, invalidArgument("number of seats to reserve must be positive")
 	}
-	return flight, nil
+
+	bookingRef, err := newBookingRef()
+	if err != nil {
+		return nil, err
+	}
+	reservation := &Reservation{
+		ID:        bookingRef,
+		FlightID:  id,
+		Seats:     seats,
+		Requester: requester,
+	}
+	if err := store.Reserve(reservation); err != nil {
+		return nil, err
+	}
+	flightChanges.publish(flightChanged, id)
+	return reservation, nil
+}
+
+// GetReservation finds a reservation by its booking reference
+func GetReservation(bookingRef string) (*Reservation, error) {
+	if bookingRef == "" {
+		return nil, ErrReservationNotFound
+	}
+	return store.GetReservation(bookingRef)
 }
 
-// MakeReservation makes flight reservation and reduce the number of available seats
-func MakeReservation(id string, seats int32) error {
-	flight, err := GetFlight(id)
+// CancelReservation cancels a reservation and returns its seats to the flight.
+// Cancelling an already cancelled reservation succeeds without crediting the
+// seats again, so a replayed cancel request is harmless.
+func CancelReservation(bookingRef string) (*Reservation, error) {
+	if bookingRef == "" {
+		return nil, ErrReservationNotFound
+	}
+	now := time.Now()
+	reservation, cancelled, err := store.CancelReservation(bookingRef, now)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if flight.AvailabeSeats < seats {
-		return errors.New("flight doesn't have enough available seats")
+	if !cancelled {
+		// already cancelled by a previous request
+		return reservation, nil
+	}
+	flightChanges.publish(flightChanged, reservation.FlightID)
+
+	reservation.Cancelled = true
+	reservation.CancelledAt = &now
+	return reservation, nil
+}
+
+const bookingRefChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newBookingRef generates a random 8 character booking reference which is
+// not yet used by any reservation
+func newBookingRef() (string, error) {
+	buf := make([]byte, 8)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for i, b := range buf {
+			buf[i] = bookingRefChars[int(b)%len(bookingRefChars)]
+		}
+		ref := string(buf)
+		if r, _ := GetReservation(ref); r == nil {
+			return ref, nil
+		}
 	}
-	flight.AvailabeSeats -= seats
-	database.DB.Save(flight)
-	return nil
 }
 
 func NewFlight(
 	id,
 	from,
-	to,
-	time string,
+	to string,
+	departureTime,
+	arrivalTime time.Time,
 	availableSeats int32,
 	fare float32) (*Flight, error) {
 
-	if f, _ := GetFlight(id); f != nil {
-		return nil, errors.New("duplicate flight number found")
-	}
-
 	flight := &Flight{
 		ID:            id,
 		From:          from,
 		To:            to,
-		Time:          time,
+		DepartureTime: departureTime,
+		ArrivalTime:   arrivalTime,
 		AvailabeSeats: availableSeats,
 		Fare:          fare,
 	}
-	database.DB.Create(flight)
+	if err := flight.Validate(); err != nil {
+		return nil, err
+	}
+	if err := store.CreateFlight(flight); err != nil {
+		return nil, err
+	}
+	flightChanges.publish(flightAdded, id)
 
 	return flight, nil
 }
 
-func MonitorAvailableSeats(id string, durationMs int32) (<-chan int32, <-chan error) {
-	resChan := make(chan int32)
-	errChan := make(chan error)
-	var prevAvailableSeats int32
-
-	go func() {
-		defer close(resChan)
-		defer close(errChan)
-
-		timeout := time.After(time.Duration(durationMs) * time.Millisecond)
-		ticker := time.Tick(500 * time.Millisecond)
+// FlightUpdate holds the fields changed by UpdateFlight, nil fields are kept.
+// A zero ArrivalTime makes the arrival time unknown.
+type FlightUpdate struct {
+	DepartureTime  *time.Time
+	ArrivalTime    *time.Time
+	AvailableSeats *int32
+	Fare           *float32
+}
 
-		query := func() {
-			flight, err := GetFlight(id)
-			if err != nil {
-				errChan <- err
-			} else if flight.AvailabeSeats != prevAvailableSeats {
-				resChan <- flight.AvailabeSeats
-				prevAvailableSeats = flight.AvailabeSeats
-			}
+// UpdateFlight changes the given fields of a flight and notifies its
+// monitors. A flight with active reservations can't be changed, an update
+// keeping all of its fields succeeds so a replayed request is harmless.
+func UpdateFlight(id string, update *FlightUpdate) (*Flight, error) {
+	flight, err := store.UpdateFlight(id, func(f *Flight, reserved bool) error {
+		changed := (update.DepartureTime != nil && !update.DepartureTime.Equal(f.DepartureTime)) ||
+			(update.ArrivalTime != nil && !update.ArrivalTime.Equal(f.ArrivalTime)) ||
+			(update.AvailableSeats != nil && *update.AvailableSeats != f.AvailabeSeats) ||
+			(update.Fare != nil && *update.Fare != f.Fare)
+		if changed && reserved {
+			return ErrFlightReserved
 		}
-
-		query()
-
-		for {
-			select {
-			case <-timeout:
-				return
-			case <-ticker:
-				query()
-			}
+		if update.DepartureTime != nil {
+			f.DepartureTime = *update.DepartureTime
+		}
+		if update.ArrivalTime != nil {
+			f.ArrivalTime = *update.ArrivalTime
 		}
-	}()
+		if update.AvailableSeats != nil {
+			f.AvailabeSeats = *update.AvailableSeats
+		}
+		if update.Fare != nil {
+			f.Fare = *update.Fare
+		}
+		return f.Validate()
+	})
+	if err != nil {
+		return nil, err
+	}
+	flightChanges.publish(flightChanged, id)
+	return flight, nil
+}
 
-	return resChan, errChan
+// DeleteFlight removes a flight without active reservations and notifies its
+// monitors
+func DeleteFlight(id string) error {
+	if id == "" {
+		return ErrFlightNotFound
+	}
+	flight, err := store.DeleteFlight(id)
+	if err != nil {
+		return err
+	}
+	flightChanges.publishRemoved(flight)
+	return nil
 }
 
 func FindDestinationsFrom(from string) ([]string, error) {
 	var destinationSet = make(map[string]bool)
 	var destinations []string
-	var flights []*Flight
 
-	database.DB.Find(&flights, Flight{From: from})
+	flights, err := store.FindFlights(&SearchQuery{From: from})
+	if err != nil {
+		return destinations, err
+	}
 	if len(flights) == 0 {
-		return destinations, errors.New("no flight found")
+		return destinations, &Error{FlightNotFoundID, "no flight found"}
 	}
 
 	for _, flight := range flights {
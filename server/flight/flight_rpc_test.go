@@ -0,0 +1,73 @@
+package flight
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/rpc"
+)
+
+// taggedFlightInfo is FlightInfo of flight.idl for rpc.Marshal
+type taggedFlightInfo struct {
+	ID             string    `rpc:"1"`
+	From           string    `rpc:"2"`
+	To             string    `rpc:"3"`
+	Time           string    `rpc:"4"`
+	AvailableSeats int32     `rpc:"5"`
+	Fare           float32   `rpc:"6"`
+	DepartureTime  time.Time `rpc:"7"`
+	ArrivalTime    time.Time `rpc:"8,optional"`
+}
+
+// TestMarshalGeneratedFlightInfo checks that rpc.Marshal and rpc.Unmarshal
+// agree with the generated codec of flightInfo on the wire
+func TestMarshalGeneratedFlightInfo(t *testing.T) {
+	departure := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	for _, f := range []*Flight{
+		{ID: "SQ1", From: "SIN", To: "HND", DepartureTime: departure, ArrivalTime: departure.Add(7 * time.Hour), AvailabeSeats: 42, Fare: 399.5},
+		{ID: "SQ2", From: "SIN", To: "BKK", DepartureTime: departure, AvailabeSeats: 0, Fare: 99},
+	} {
+		info := newFlightInfo(f)
+		generated := rpc.NewMemoryBuffer()
+		if err := info.write(rpc.NewBinaryProtocol(generated)); err != nil {
+			t.Fatal(err)
+		}
+		wire := append([]byte(nil), generated.Bytes()...)
+
+		tagged := &taggedFlightInfo{}
+		if err := rpc.Unmarshal(rpc.NewBinaryProtocol(generated), tagged); err != nil {
+			t.Fatal(err)
+		}
+		want := &taggedFlightInfo{
+			ID:             f.ID,
+			From:           f.From,
+			To:             f.To,
+			Time:           info.time,
+			AvailableSeats: f.AvailabeSeats,
+			Fare:           f.Fare,
+			DepartureTime:  f.DepartureTime,
+			ArrivalTime:    f.ArrivalTime,
+		}
+		if !reflect.DeepEqual(tagged, want) {
+			t.Errorf("generated flightInfo unmarshaled to\n%+v\nwant\n%+v", tagged, want)
+		}
+
+		marshaled := rpc.NewMemoryBuffer()
+		if err := rpc.Marshal(rpc.NewBinaryProtocol(marshaled), want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(marshaled.Bytes(), wire) {
+			t.Errorf("flight %s marshaled to\n%x\ngenerated code writes\n%x", f.ID, marshaled.Bytes(), wire)
+		}
+
+		read := &flightInfo{}
+		if err := read.read(rpc.NewBinaryProtocol(marshaled)); err != nil {
+			t.Fatal(err)
+		}
+		if *read != *info {
+			t.Errorf("marshaled flight read by generated code as %+v, want %+v", read, info)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package flight
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateReservedFlight(t *testing.T) {
+	Init(NewMemoryStore())
+	departure := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	if _, err := NewFlight("SQ1", "SIN", "HND", departure, time.Time{}, 10, 100); err != nil {
+		t.Fatal(err)
+	}
+	reservation, err := MakeReservation("SQ1", 4, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seats, fare, later := int32(2), float32(120), departure.Add(time.Hour)
+	for _, update := range []*FlightUpdate{
+		{AvailableSeats: &seats},
+		{Fare: &fare},
+		{DepartureTime: &later},
+	} {
+		if _, err := UpdateFlight("SQ1", update); err != ErrFlightReserved {
+			t.Errorf("update %+v of reserved flight: %v", update, err)
+		}
+	}
+	// unchanged fields are no change
+	same := int32(6)
+	if _, err := UpdateFlight("SQ1", &FlightUpdate{AvailableSeats: &same}); err != nil {
+		t.Errorf("update keeping the seats of reserved flight: %v", err)
+	}
+
+	if _, err := CancelReservation(reservation.ID); err != nil {
+		t.Fatal(err)
+	}
+	flight, err := UpdateFlight("SQ1", &FlightUpdate{AvailableSeats: &seats})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flight.AvailabeSeats != seats {
+		t.Errorf("updated flight has %d seats, want %d", flight.AvailabeSeats, seats)
+	}
+}
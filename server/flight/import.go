@@ -0,0 +1,313 @@
+package flight
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of imported and exported flights
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// flightRecord is a flight as imported and exported, with formatted times
+type flightRecord struct {
+	ID        string  `json:"id"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Departure string  `json:"departure"`
+	Arrival   string  `json:"arrival,omitempty"`
+	Seats     int32   `json:"seats"`
+	Fare      float32 `json:"fare"`
+}
+
+// csvColumns are the columns of a CSV file in export order, the columns of
+// an imported file are found by their header and arrival may be left out
+var csvColumns = []string{"id", "from", "to", "departure", "arrival", "seats", "fare"}
+
+func newFlightRecord(f *Flight) *flightRecord {
+	r := &flightRecord{
+		ID:        f.ID,
+		From:      f.From,
+		To:        f.To,
+		Departure: f.DepartureTime.Format(time.RFC3339),
+		Seats:     f.AvailabeSeats,
+		Fare:      f.Fare,
+	}
+	if !f.ArrivalTime.IsZero() {
+		r.Arrival = f.ArrivalTime.Format(time.RFC3339)
+	}
+	return r
+}
+
+func (r *flightRecord) flight() (*Flight, error) {
+	f := &Flight{
+		ID:            strings.TrimSpace(r.ID),
+		From:          strings.TrimSpace(r.From),
+		To:            strings.TrimSpace(r.To),
+		AvailabeSeats: r.Seats,
+		Fare:          r.Fare,
+	}
+	var err error
+	if r.Departure != "" {
+		if f.DepartureTime, err = ParseTime(strings.TrimSpace(r.Departure)); err != nil {
+			return nil, fmt.Errorf("departure: %v", err)
+		}
+	}
+	if r.Arrival != "" {
+		if f.ArrivalTime, err = ParseTime(strings.TrimSpace(r.Arrival)); err != nil {
+			return nil, fmt.Errorf("arrival: %v", err)
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Actions of an imported row
+const (
+	ImportNew       = "new"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportRejected  = "rejected"
+)
+
+// ImportRow is a flight read from an import file. Rows are numbered from 1
+// without the CSV header. Flight is nil and Err is set if the row is
+// invalid.
+type ImportRow struct {
+	Row    int
+	ID     string
+	Flight *Flight
+	Err    error
+	// Action is set by PlanImport
+	Action string
+}
+
+// ReadFlights reads the flights of an import file in the given format. Only
+// an unreadable file is an error, invalid rows are returned with their error.
+func ReadFlights(r io.Reader, format string) ([]*ImportRow, error) {
+	var records []*flightRecord
+	var rows []*ImportRow
+	switch format {
+	case FormatCSV:
+		var err error
+		if records, rows, err = readCSVRecords(r); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		for i, record := range records {
+			if record == nil {
+				records[i] = &flightRecord{}
+			}
+			rows = append(rows, &ImportRow{Row: i + 1})
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	for i, row := range rows {
+		if row.Err != nil {
+			continue
+		}
+		row.ID = strings.TrimSpace(records[i].ID)
+		row.Flight, row.Err = records[i].flight()
+	}
+	return rows, nil
+}
+
+func readCSVRecords(r io.Reader) ([]*flightRecord, []*ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok && name != "arrival" {
+			return nil, nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+	reader.FieldsPerRecord = len(header)
+
+	var records []*flightRecord
+	var rows []*ImportRow
+	for n := 1; ; n++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		record := &flightRecord{}
+		row := &ImportRow{Row: n}
+		records = append(records, record)
+		rows = append(rows, row)
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, nil, err
+			}
+			row.Err = err
+			continue
+		}
+
+		get := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		record.ID = get("id")
+		record.From = get("from")
+		record.To = get("to")
+		record.Departure = get("departure")
+		record.Arrival = get("arrival")
+		seats, err := strconv.ParseInt(get("seats"), 10, 32)
+		if err != nil {
+			row.ID, row.Err = record.ID, fmt.Errorf("seats: invalid number %q", get("seats"))
+			continue
+		}
+		fare, err := strconv.ParseFloat(get("fare"), 32)
+		if err != nil {
+			row.ID, row.Err = record.ID, fmt.Errorf("fare: invalid number %q", get("fare"))
+			continue
+		}
+		record.Seats = int32(seats)
+		record.Fare = float32(fare)
+	}
+	return records, rows, nil
+}
+
+// ImportPlan is what an import would change, and the rows it rejects
+type ImportPlan struct {
+	Rows    []*ImportRow
+	Replace bool
+
+	New, Updated, Unchanged, Rejected int
+}
+
+// OK returns true if no row is rejected, only then can the plan be imported
+func (p *ImportPlan) OK() bool {
+	return p.Rejected == 0
+}
+
+// PlanImport compares the rows with the stored flights. A row with the ID of
+// a stored flight updates it if replace is set and is rejected otherwise. A
+// row repeating an ID of the file is always rejected.
+func PlanImport(rows []*ImportRow, replace bool) (*ImportPlan, error) {
+	plan := &ImportPlan{Rows: rows, Replace: replace}
+	seen := make(map[string]int)
+	for _, row := range rows {
+		if row.Err == nil {
+			if first, ok := seen[row.ID]; ok {
+				row.Err = fmt.Errorf("duplicate flight number, first at row %d", first)
+			}
+		}
+		if row.Err != nil {
+			row.Action = ImportRejected
+			plan.Rejected++
+			continue
+		}
+		seen[row.ID] = row.Row
+
+		existing, err := store.GetFlight(row.ID)
+		switch {
+		case err == ErrFlightNotFound:
+			row.Action = ImportNew
+			plan.New++
+		case err != nil:
+			return nil, err
+		case sameFlight(existing, row.Flight):
+			row.Action = ImportUnchanged
+			plan.Unchanged++
+		case replace:
+			row.Action = ImportUpdate
+			plan.Updated++
+		default:
+			row.Err = ErrDuplicateFlight
+			row.Action = ImportRejected
+			plan.Rejected++
+		}
+	}
+	return plan, nil
+}
+
+func sameFlight(a, b *Flight) bool {
+	return a.ID == b.ID && a.From == b.From && a.To == b.To &&
+		a.DepartureTime.Equal(b.DepartureTime) && a.ArrivalTime.Equal(b.ArrivalTime) &&
+		a.AvailabeSeats == b.AvailabeSeats && a.Fare == b.Fare
+}
+
+// ImportFlights saves the new and updated flights of the plan, all or none.
+// Monitors of a server using the same database aren't notified.
+func ImportFlights(plan *ImportPlan) error {
+	if !plan.OK() {
+		return errors.New("import has rejected rows")
+	}
+	var flights []*Flight
+	for _, row := range plan.Rows {
+		if row.Action == ImportNew || row.Action == ImportUpdate {
+			flights = append(flights, row.Flight)
+		}
+	}
+	return store.SaveFlights(flights, plan.Replace)
+}
+
+// ExportFlights writes all flights in the given format, ordered by departure
+func ExportFlights(w io.Writer, format string) error {
+	flights, err := store.FindFlights(&SearchQuery{})
+	if err != nil {
+		return err
+	}
+	sort.Slice(flights, func(i, j int) bool {
+		if !flights[i].DepartureTime.Equal(flights[j].DepartureTime) {
+			return flights[i].DepartureTime.Before(flights[j].DepartureTime)
+		}
+		return flights[i].ID < flights[j].ID
+	})
+
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(csvColumns)
+		for _, f := range flights {
+			r := newFlightRecord(f)
+			writer.Write([]string{
+				r.ID, r.From, r.To, r.Departure, r.Arrival,
+				strconv.FormatInt(int64(r.Seats), 10),
+				strconv.FormatFloat(float64(r.Fare), 'f', -1, 32),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case FormatJSON:
+		records := make([]*flightRecord, 0, len(flights))
+		for _, f := range flights {
+			records = append(records, newFlightRecord(f))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
@@ -0,0 +1,169 @@
+package flight
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore is a FlightStore keeping flights in memory only, everything is
+// lost when the server stops
+type MemoryStore struct {
+	mu           sync.RWMutex
+	flights      map[string]*Flight
+	reservations map[string]*Reservation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		flights:      make(map[string]*Flight),
+		reservations: make(map[string]*Reservation),
+	}
+}
+
+// copies are returned so callers can't change the stored values
+
+func (s *MemoryStore) GetFlight(id string) (*Flight, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flight, ok := s.flights[id]
+	if !ok {
+		return nil, ErrFlightNotFound
+	}
+	f := *flight
+	return &f, nil
+}
+
+func (s *MemoryStore) FindFlights(q *SearchQuery) ([]*Flight, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var flights []*Flight
+	for _, flight := range s.flights {
+		if q.match(flight) {
+			f := *flight
+			flights = append(flights, &f)
+		}
+	}
+	return flights, nil
+}
+
+func (s *MemoryStore) CreateFlight(flight *Flight) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flights[flight.ID]; ok {
+		return ErrDuplicateFlight
+	}
+	f := *flight
+	s.flights[flight.ID] = &f
+	return nil
+}
+
+func (s *MemoryStore) SaveFlights(flights []*Flight, replace bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !replace {
+		for _, flight := range flights {
+			if _, ok := s.flights[flight.ID]; ok {
+				return prefixError(flight.ID, ErrDuplicateFlight)
+			}
+		}
+	}
+	for _, flight := range flights {
+		f := *flight
+		s.flights[flight.ID] = &f
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateFlight(id string, change func(flight *Flight, reserved bool) error) (*Flight, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flight, ok := s.flights[id]
+	if !ok {
+		return nil, ErrFlightNotFound
+	}
+	f := *flight
+	if err := change(&f, s.reserved(id)); err != nil {
+		return nil, err
+	}
+	stored := f
+	s.flights[id] = &stored
+	return &f, nil
+}
+
+func (s *MemoryStore) DeleteFlight(id string) (*Flight, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flight, ok := s.flights[id]
+	if !ok {
+		return nil, ErrFlightNotFound
+	}
+	if s.reserved(id) {
+		return nil, ErrFlightReserved
+	}
+	delete(s.flights, id)
+	return flight, nil
+}
+
+// reserved returns true if the flight has active reservations, s.mu must be
+// held
+func (s *MemoryStore) reserved(id string) bool {
+	for _, reservation := range s.reservations {
+		if reservation.FlightID == id && !reservation.Cancelled {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) Reserve(reservation *Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flight, ok := s.flights[reservation.FlightID]
+	if !ok {
+		return ErrFlightNotFound
+	}
+	if flight.AvailabeSeats < reservation.Seats {
+		return ErrNotEnoughSeats
+	}
+	flight.AvailabeSeats -= reservation.Seats
+	if reservation.CreatedAt.IsZero() {
+		reservation.CreatedAt = time.Now()
+	}
+	r := *reservation
+	s.reservations[reservation.ID] = &r
+	return nil
+}
+
+func (s *MemoryStore) GetReservation(bookingRef string) (*Reservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reservation, ok := s.reservations[bookingRef]
+	if !ok {
+		return nil, ErrReservationNotFound
+	}
+	r := *reservation
+	return &r, nil
+}
+
+func (s *MemoryStore) CancelReservation(bookingRef string, at time.Time) (*Reservation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reservation, ok := s.reservations[bookingRef]
+	if !ok {
+		return nil, false, ErrReservationNotFound
+	}
+	prev := *reservation
+	if reservation.Cancelled {
+		return &prev, false, nil
+	}
+	reservation.Cancelled = true
+	reservation.CancelledAt = &at
+	if flight, ok := s.flights[reservation.FlightID]; ok {
+		flight.AvailabeSeats += reservation.Seats
+	}
+	return &prev, true, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
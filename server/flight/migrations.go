@@ -0,0 +1,214 @@
+package flight
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/database"
+	"github.com/jinzhu/gorm"
+)
+
+// Migrations are the schema changes of the sqlite store, in order. Released
+// migrations must not be changed, a schema change is a new migration.
+var Migrations = []database.Migration{
+	{
+		Version: 1,
+		Name:    "create flights and reservations",
+		// the tables as created by gorm AutoMigrate before migrations were
+		// versioned, databases created then are taken over as they are. The
+		// flights table of servers before flight times were timestamps has no
+		// departure_time to index yet, it's upgraded by migration 2.
+		Up: func(tx *gorm.DB) error {
+			err := execAll(
+				`CREATE TABLE IF NOT EXISTS "flights" ("id" varchar(255),"from" varchar(255),"to" varchar(255),"departure_time" datetime,"arrival_time" datetime,"availabe_seats" integer,"fare" real , PRIMARY KEY ("id"))`,
+				`CREATE INDEX IF NOT EXISTS idx_flights_from ON "flights"("from")`,
+				`CREATE INDEX IF NOT EXISTS idx_flights_to ON "flights"("to")`,
+				`CREATE TABLE IF NOT EXISTS "reservations" ("id" varchar(255),"flight_id" varchar(255),"seats" integer,"requester" varchar(255),"created_at" datetime,"cancelled" bool,"cancelled_at" datetime , PRIMARY KEY ("id"))`,
+				`CREATE INDEX IF NOT EXISTS idx_reservations_flight_id ON "reservations"(flight_id)`,
+			)(tx)
+			if err != nil {
+				return err
+			}
+			columns, err := tableColumns(tx, "flights")
+			if err != nil || !columns["departure_time"] {
+				return err
+			}
+			return tx.Exec(`CREATE INDEX IF NOT EXISTS idx_flights_departure_time ON "flights"(departure_time)`).Error
+		},
+		Down: execAll(
+			`DROP TABLE IF EXISTS "reservations"`,
+			`DROP TABLE IF EXISTS "flights"`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "upgrade legacy flights table",
+		Up:      upgradeLegacyFlights,
+		Down:    downgradeLegacyFlights,
+	},
+}
+
+func execAll(statements ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// tableColumns returns the columns of a table, none if it doesn't exist
+func tableColumns(tx *gorm.DB, table string) (map[string]bool, error) {
+	rows, err := tx.Raw(fmt.Sprintf(`PRAGMA table_info("%s")`, table)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			defaultValue     interface{}
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// rebuildFlights replaces the flights table by one created with createStmt,
+// copying the given columns. The indexes of the old table are dropped with it.
+func rebuildFlights(tx *gorm.DB, createStmt, columns string) error {
+	return execAll(
+		`DROP TABLE IF EXISTS "flights_rebuild"`,
+		fmt.Sprintf(createStmt, "flights_rebuild"),
+		fmt.Sprintf(`INSERT INTO "flights_rebuild" (%s) SELECT %s FROM "flights"`, columns, columns),
+		`DROP TABLE "flights"`,
+		`ALTER TABLE "flights_rebuild" RENAME TO "flights"`,
+	)(tx)
+}
+
+// legacyTimes reads the id and "time" column of the flights matching where
+func legacyTimes(tx *gorm.DB, where string) (map[string]string, error) {
+	rows, err := tx.Raw(`SELECT "id", "time" FROM "flights" WHERE ` + where).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[string]string)
+	for rows.Next() {
+		var id string
+		var value *string
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			times[id] = ""
+		} else {
+			times[id] = *value
+		}
+	}
+	return times, rows.Err()
+}
+
+// upgradeLegacyFlights moves the departure of flights from the free-form
+// "time" column to departure_time, adding departure_time and arrival_time if
+// missing, and drops the "time" column. Flights with a time which can't be
+// parsed are logged and get a zero departure time.
+func upgradeLegacyFlights(tx *gorm.DB) error {
+	columns, err := tableColumns(tx, "flights")
+	if err != nil {
+		return err
+	}
+	if !columns["time"] {
+		return nil
+	}
+
+	for _, column := range []string{"departure_time", "arrival_time"} {
+		if !columns[column] {
+			if err := tx.Exec(fmt.Sprintf(`ALTER TABLE "flights" ADD COLUMN "%s" datetime`, column)).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	// flights created after the switch to timestamps but before migrations
+	// already have a departure time
+	times, err := legacyTimes(tx, `"departure_time" IS NULL`)
+	if err != nil {
+		return err
+	}
+	for id, value := range times {
+		departure, err := ParseTime(value)
+		if err != nil {
+			log.Printf("flight %s: can't parse time %q, departure time set to zero: %v", id, value, err)
+			departure = time.Time{}
+		}
+		if err := tx.Exec(`UPDATE "flights" SET "departure_time" = ? WHERE "id" = ?`, departure, id).Error; err != nil {
+			return err
+		}
+	}
+	// the arrival of legacy flights is unknown
+	if err := tx.Exec(`UPDATE "flights" SET "arrival_time" = ? WHERE "arrival_time" IS NULL`, time.Time{}).Error; err != nil {
+		return err
+	}
+
+	err = rebuildFlights(tx,
+		`CREATE TABLE "%s" ("id" varchar(255),"from" varchar(255),"to" varchar(255),"departure_time" datetime,"arrival_time" datetime,"availabe_seats" integer,"fare" real , PRIMARY KEY ("id"))`,
+		`"id","from","to","departure_time","arrival_time","availabe_seats","fare"`,
+	)
+	if err != nil {
+		return err
+	}
+	return execAll(
+		`CREATE INDEX IF NOT EXISTS idx_flights_from ON "flights"("from")`,
+		`CREATE INDEX IF NOT EXISTS idx_flights_to ON "flights"("to")`,
+		`CREATE INDEX IF NOT EXISTS idx_flights_departure_time ON "flights"(departure_time)`,
+	)(tx)
+}
+
+// downgradeLegacyFlights reverts upgradeLegacyFlights, formatting departure
+// times into the "time" column with TimeLayout. Arrival times are lost.
+func downgradeLegacyFlights(tx *gorm.DB) error {
+	columns, err := tableColumns(tx, "flights")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 || columns["time"] {
+		return nil
+	}
+
+	var flights []*Flight
+	if err := tx.Find(&flights).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec(`ALTER TABLE "flights" ADD COLUMN "time" varchar(255)`).Error; err != nil {
+		return err
+	}
+	for _, f := range flights {
+		value := f.DepartureTime.Format(TimeLayout)
+		if err := tx.Exec(`UPDATE "flights" SET "time" = ? WHERE "id" = ?`, value, f.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	err = rebuildFlights(tx,
+		`CREATE TABLE "%s" ("id" varchar(255),"from" varchar(255),"to" varchar(255),"time" varchar(255),"availabe_seats" integer,"fare" real , PRIMARY KEY ("id"))`,
+		`"id","from","to","time","availabe_seats","fare"`,
+	)
+	if err != nil {
+		return err
+	}
+	return execAll(
+		`CREATE INDEX IF NOT EXISTS idx_flights_from ON "flights"("from")`,
+		`CREATE INDEX IF NOT EXISTS idx_flights_to ON "flights"("to")`,
+	)(tx)
+}
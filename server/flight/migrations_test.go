@@ -0,0 +1,127 @@
+package flight
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/database"
+	"github.com/jinzhu/gorm"
+)
+
+// the flights table of servers before flight times were timestamps, as created
+// by gorm AutoMigrate
+var legacyFlightsSchema = []string{
+	`CREATE TABLE "flights" ("id" varchar(255),"from" varchar(255),"to" varchar(255),"time" varchar(255),"availabe_seats" integer,"fare" real , PRIMARY KEY ("id"))`,
+	`CREATE INDEX idx_flights_from ON "flights"("from")`,
+	`CREATE INDEX idx_flights_to ON "flights"("to")`,
+}
+
+func openLegacyDB(t *testing.T, times ...string) *gorm.DB {
+	db, err := database.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := execAll(legacyFlightsSchema...)(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	for i, value := range times {
+		err := db.Exec(`INSERT INTO "flights" ("id","from","to","time","availabe_seats","fare") VALUES (?,?,?,?,?,?)`,
+			fmt.Sprintf("SQ%d", i+1), "SIN", "HND", value, 10, 99.5).Error
+		if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestMigrateLegacyFlights(t *testing.T) {
+	db := openLegacyDB(t, "2019-04-01 10:30 +08:00", "2019-04-02 08:00")
+	s, err := NewSQLiteStore(db)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	want := map[string]time.Time{
+		"SQ1": time.Date(2019, 4, 1, 2, 30, 0, 0, time.UTC),
+		"SQ2": time.Date(2019, 4, 2, 8, 0, 0, 0, time.UTC),
+	}
+	for id, departure := range want {
+		f, err := s.GetFlight(id)
+		if err != nil {
+			t.Fatalf("flight %s: %v", id, err)
+		}
+		if !f.DepartureTime.Equal(departure) || !f.ArrivalTime.IsZero() || f.AvailabeSeats != 10 {
+			t.Errorf("flight %s migrated to %+v", id, f)
+		}
+	}
+
+	columns, err := tableColumns(db, "flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["time"] {
+		t.Error("legacy time column was kept")
+	}
+	if err := s.CreateFlight(&Flight{ID: "SQ3", From: "SIN", To: "HND", DepartureTime: want["SQ1"]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindFlights(&SearchQuery{DepartAfter: want["SQ1"]}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLegacyFlightsInvalidTime(t *testing.T) {
+	db := openLegacyDB(t, "tomorrow morning", "", "2019-04-02 08:00")
+	defer db.Close()
+
+	if err := database.Migrate(db, Migrations, database.Latest); err != nil {
+		t.Fatalf("migrating invalid time: %v", err)
+	}
+	var flights []*Flight
+	if err := db.Order("id").Find(&flights).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(flights) != 3 {
+		t.Fatalf("%d flights migrated, want 3", len(flights))
+	}
+	for _, f := range flights[:2] {
+		if !f.DepartureTime.IsZero() {
+			t.Errorf("flight %s with invalid time departs at %v", f.ID, f.DepartureTime)
+		}
+	}
+	if want := time.Date(2019, 4, 2, 8, 0, 0, 0, time.UTC); !flights[2].DepartureTime.Equal(want) {
+		t.Errorf("flight %s departs at %v, want %v", flights[2].ID, flights[2].DepartureTime, want)
+	}
+}
+
+func TestDowngradeLegacyFlights(t *testing.T) {
+	db := openLegacyDB(t, "2019-04-01 10:30 +08:00")
+	defer db.Close()
+
+	if err := database.Migrate(db, Migrations, database.Latest); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(db, Migrations, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	columns, err := tableColumns(db, "flights")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !columns["time"] || columns["departure_time"] || columns["arrival_time"] {
+		t.Fatalf("downgraded flights have columns %v", columns)
+	}
+	var value string
+	if err := db.Raw(`SELECT "time" FROM "flights" WHERE "id" = ?`, "SQ1").Row().Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "2019-04-01 10:30 +08:00" {
+		t.Fatalf("downgraded time is %q", value)
+	}
+}
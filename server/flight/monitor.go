@@ -0,0 +1,488 @@
+package flight
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Caps on the number of active monitor subscriptions, in total and for a
+// single client address, and on the flight IDs listed by a subscription
+var (
+	MaxMonitors          = 1000
+	MaxMonitorsPerClient = 10
+	MaxMonitorFlights    = 100
+)
+
+// Retransmission of unacknowledged updates of reliable monitors. The interval
+// doubles after every retry up to MaxCallbackRetryInterval, a subscriber not
+// acknowledging updates after MaxCallbackRetries retries is dropped as dead.
+var (
+	CallbackRetryInterval    = 500 * time.Millisecond
+	MaxCallbackRetryInterval = 5 * time.Second
+	MaxCallbackRetries       = 5
+)
+
+// MonitorQuery selects the flights covered by a monitor subscription: the
+// listed flights, and the flights of a route if From or To is set
+type MonitorQuery struct {
+	FlightIDs []string
+	From      string
+	To        string
+}
+
+func (q *MonitorQuery) hasRoute() bool {
+	return q.From != "" || q.To != ""
+}
+
+func (q *MonitorQuery) matches(flight *Flight) bool {
+	for _, id := range q.FlightIDs {
+		if flight.ID == id {
+			return true
+		}
+	}
+	return q.hasRoute() &&
+		(q.From == "" || flight.From == q.From) &&
+		(q.To == "" || flight.To == q.To)
+}
+
+// flights returns the flights currently covered by the query
+func (q *MonitorQuery) flights() ([]*Flight, error) {
+	if len(q.FlightIDs) == 0 && !q.hasRoute() {
+		return nil, invalidArgument("no flight or route to monitor")
+	}
+	if len(q.FlightIDs) > MaxMonitorFlights {
+		return nil, invalidArgument("cannot monitor more than %d flights", MaxMonitorFlights)
+	}
+
+	var flights []*Flight
+	seen := make(map[string]bool)
+	for _, id := range q.FlightIDs {
+		if seen[id] {
+			continue
+		}
+		flight, err := GetFlight(id)
+		if err != nil {
+			return nil, prefixError(id, err)
+		}
+		flights = append(flights, flight)
+		seen[id] = true
+	}
+	if q.hasRoute() {
+		route, err := store.FindFlights(&SearchQuery{From: q.From, To: q.To})
+		if err != nil {
+			return nil, err
+		}
+		for _, flight := range route {
+			if !seen[flight.ID] {
+				flights = append(flights, flight)
+				seen[flight.ID] = true
+			}
+		}
+	}
+	return flights, nil
+}
+
+// Monitor is a subscription to the changes of flights. Its ID is a random
+// token, anyone knowing it can renew or cancel the subscription.
+type Monitor struct {
+	ID        string
+	Query     MonitorQuery
+	Client    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// MonitorEvent is the reason of a monitor update
+type MonitorEvent string
+
+const (
+	// EventSeats carries the available seats of a flight, it's sent for every
+	// covered flight when the subscription starts and whenever they change
+	EventSeats MonitorEvent = "seats"
+	// EventFare is sent when the fare of a flight changes
+	EventFare MonitorEvent = "fare"
+	// EventNewFlight is sent when a flight matching the route is added
+	EventNewFlight MonitorEvent = "newFlight"
+	// EventSchedule is sent when the departure or arrival time of a flight
+	// changes
+	EventSchedule MonitorEvent = "schedule"
+	// EventRemoved is sent when a flight is deleted, no further updates of
+	// it follow
+	EventRemoved MonitorEvent = "removed"
+	// EventRenewed is sent when the subscription is renewed and carries no
+	// flight
+	EventRenewed MonitorEvent = "renewed"
+)
+
+// MonitorUpdate is sent to a monitor subscriber. Updates are numbered from 1,
+// a retransmitted update keeps its sequence number.
+type MonitorUpdate struct {
+	Sequence       int32
+	Event          MonitorEvent
+	FlightID       string
+	AvailableSeats int32
+	Fare           float32
+	DepartureTime  time.Time
+	ArrivalTime    time.Time
+	ExpiresAt      time.Time
+}
+
+type monitorEntry struct {
+	Monitor
+	cancelled chan struct{}
+	renewed   chan struct{}
+	// sequence numbers acknowledged by the subscriber
+	acks chan int32
+}
+
+type monitorRegistry struct {
+	mu        sync.Mutex
+	monitors  map[string]*monitorEntry
+	perClient map[string]int
+}
+
+var monitors = &monitorRegistry{
+	monitors:  make(map[string]*monitorEntry),
+	perClient: make(map[string]int),
+}
+
+func (r *monitorRegistry) add(query MonitorQuery, client string, duration time.Duration) (*monitorEntry, error) {
+	id, err := newMonitorID()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.monitors) >= MaxMonitors {
+		return nil, ErrTooManyMonitors
+	}
+	if r.perClient[client] >= MaxMonitorsPerClient {
+		return nil, ErrTooManyClientMonitors
+	}
+
+	now := time.Now()
+	entry := &monitorEntry{
+		Monitor: Monitor{
+			ID:        id,
+			Query:     query,
+			Client:    client,
+			CreatedAt: now,
+			ExpiresAt: now.Add(duration),
+		},
+		cancelled: make(chan struct{}),
+		renewed:   make(chan struct{}, 1),
+		acks:      make(chan int32, 64),
+	}
+	r.monitors[id] = entry
+	r.perClient[client]++
+	return entry, nil
+}
+
+// remove deletes the entry, returning false if it was already removed
+func (r *monitorRegistry) remove(entry *monitorEntry) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.monitors[entry.ID] != entry {
+		return false
+	}
+	delete(r.monitors, entry.ID)
+	if r.perClient[entry.Client]--; r.perClient[entry.Client] <= 0 {
+		delete(r.perClient, entry.Client)
+	}
+	return true
+}
+
+func (r *monitorRegistry) expiresAt(entry *monitorEntry) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return entry.ExpiresAt
+}
+
+func newMonitorID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// MonitorFlights subscribes client to the flights selected by query for the
+// given duration. The available seats of every covered flight are sent on the
+// returned channel, followed by an update for every change and renewal until
+// the subscription expires or is cancelled, then the channel is closed.
+// Changes are pushed by reservations, cancellations and new flights as they
+// are committed, flights aren't polled.
+//
+// If reliable is set the subscriber has to acknowledge every update with
+// AckMonitor. The latest update of every flight and event is sent again until
+// it is acknowledged or superseded, and the subscription ends if the
+// subscriber stops responding.
+func MonitorFlights(query MonitorQuery, client string, duration time.Duration, reliable bool) (*Monitor, <-chan *MonitorUpdate, error) {
+	if duration <= 0 {
+		return nil, nil, invalidArgument("monitor duration must be positive")
+	}
+
+	// subscribe before reading the flights so no change is missed
+	changes, unsubscribe := flightChanges.subscribe(query.matches)
+	flights, err := query.flights()
+	if err != nil {
+		unsubscribe()
+		return nil, nil, err
+	}
+	entry, err := monitors.add(query, client, duration)
+	if err != nil {
+		unsubscribe()
+		return nil, nil, err
+	}
+
+	m := &monitorSender{
+		entry:    entry,
+		reliable: reliable,
+		updates:  make(chan *MonitorUpdate),
+		known:    make(map[string]Flight),
+		unacked:  make(map[updateKey]*MonitorUpdate),
+	}
+	go func() {
+		defer close(m.updates)
+		defer unsubscribe()
+		defer monitors.remove(entry)
+		m.run(flights, changes)
+	}()
+
+	monitor := entry.Monitor
+	return &monitor, m.updates, nil
+}
+
+type updateKey struct {
+	flightID string
+	event    MonitorEvent
+}
+
+// monitorSender sends the updates of a monitor subscription
+type monitorSender struct {
+	entry    *monitorEntry
+	reliable bool
+	updates  chan *MonitorUpdate
+
+	sequence int32
+	// last state of the covered flights sent to the subscriber
+	known map[string]Flight
+
+	// latest unacknowledged update of every flight and event, and when to
+	// send them again
+	unacked       map[updateKey]*MonitorUpdate
+	retry         <-chan time.Time
+	retryInterval time.Duration
+	retries       int
+}
+
+func (m *monitorSender) run(flights []*Flight, changes *changeSubscriber) {
+	for _, flight := range flights {
+		m.known[flight.ID] = *flight
+		m.send(EventSeats, flight)
+	}
+
+	timer := time.NewTimer(time.Until(monitors.expiresAt(m.entry)))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			remaining := time.Until(monitors.expiresAt(m.entry))
+			if remaining <= 0 {
+				return
+			}
+			timer.Reset(remaining)
+		case <-m.entry.renewed:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(time.Until(monitors.expiresAt(m.entry)))
+			m.send(EventRenewed, nil)
+		case <-m.entry.cancelled:
+			return
+		case sequence := <-m.entry.acks:
+			m.ack(sequence)
+		case <-m.retry:
+			if m.retries >= MaxCallbackRetries {
+				log.Printf("monitor %s: no acknowledgement from %s, dropping subscriber\n", m.entry.ID, m.entry.Client)
+				return
+			}
+			m.retries++
+			m.resend()
+		case <-changes.notify:
+			for _, change := range changes.take() {
+				m.change(change)
+			}
+		}
+	}
+}
+
+// change sends the updates of a flight change compared to the known state
+func (m *monitorSender) change(change *flightChange) {
+	flight := &change.flight
+	prev, known := m.known[flight.ID]
+	if change.kind == flightRemoved {
+		if known {
+			delete(m.known, flight.ID)
+			// earlier updates of the flight don't matter anymore
+			for key := range m.unacked {
+				if key.flightID == flight.ID {
+					delete(m.unacked, key)
+				}
+			}
+			m.send(EventRemoved, flight)
+		}
+		return
+	}
+	m.known[flight.ID] = *flight
+	switch {
+	case !known && change.kind == flightAdded:
+		m.send(EventNewFlight, flight)
+	case !known:
+		m.send(EventSeats, flight)
+	default:
+		if flight.AvailabeSeats != prev.AvailabeSeats {
+			m.send(EventSeats, flight)
+		}
+		if flight.Fare != prev.Fare {
+			m.send(EventFare, flight)
+		}
+		if !flight.DepartureTime.Equal(prev.DepartureTime) || !flight.ArrivalTime.Equal(prev.ArrivalTime) {
+			m.send(EventSchedule, flight)
+		}
+	}
+}
+
+func (m *monitorSender) send(event MonitorEvent, flight *Flight) {
+	m.sequence++
+	update := &MonitorUpdate{
+		Sequence:  m.sequence,
+		Event:     event,
+		ExpiresAt: monitors.expiresAt(m.entry),
+	}
+	if flight != nil {
+		update.FlightID = flight.ID
+		update.AvailableSeats = flight.AvailabeSeats
+		update.Fare = flight.Fare
+		update.DepartureTime = flight.DepartureTime
+		update.ArrivalTime = flight.ArrivalTime
+	}
+	m.updates <- update
+
+	if m.reliable {
+		m.unacked[updateKey{update.FlightID, event}] = update
+		if m.retry == nil {
+			m.retries = 0
+			m.retryInterval = CallbackRetryInterval
+			m.retry = time.After(m.retryInterval)
+		}
+	}
+}
+
+func (m *monitorSender) ack(sequence int32) {
+	for key, update := range m.unacked {
+		if update.Sequence == sequence {
+			delete(m.unacked, key)
+			// the subscriber is alive
+			m.retries = 0
+		}
+	}
+	if len(m.unacked) == 0 {
+		m.retry = nil
+	}
+}
+
+// resend sends the unacknowledged updates again in their original order
+func (m *monitorSender) resend() {
+	updates := make([]*MonitorUpdate, 0, len(m.unacked))
+	for _, update := range m.unacked {
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Sequence < updates[j].Sequence
+	})
+	for _, update := range updates {
+		m.updates <- update
+	}
+
+	if m.retryInterval *= 2; m.retryInterval > MaxCallbackRetryInterval {
+		m.retryInterval = MaxCallbackRetryInterval
+	}
+	m.retry = time.After(m.retryInterval)
+}
+
+// CancelMonitor ends a monitor subscription before it expires
+func CancelMonitor(id string) error {
+	monitors.mu.Lock()
+	entry, ok := monitors.monitors[id]
+	monitors.mu.Unlock()
+	if !ok || !monitors.remove(entry) {
+		return ErrMonitorNotFound
+	}
+	close(entry.cancelled)
+	return nil
+}
+
+// AckMonitor acknowledges the update of a reliable monitor subscription with
+// the given sequence number
+func AckMonitor(id string, sequence int32) error {
+	monitors.mu.Lock()
+	entry, ok := monitors.monitors[id]
+	monitors.mu.Unlock()
+	if !ok {
+		return ErrMonitorNotFound
+	}
+	select {
+	case entry.acks <- sequence:
+	default:
+		// the update will be sent and acknowledged again
+	}
+	return nil
+}
+
+// RenewMonitor makes a monitor subscription expire after the given duration
+// from now instead of its current expiry
+func RenewMonitor(id string, duration time.Duration) (*Monitor, error) {
+	if duration <= 0 {
+		return nil, invalidArgument("monitor duration must be positive")
+	}
+
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	entry, ok := monitors.monitors[id]
+	if !ok {
+		return nil, ErrMonitorNotFound
+	}
+	entry.ExpiresAt = time.Now().Add(duration)
+	select {
+	case entry.renewed <- struct{}{}:
+	default:
+	}
+
+	monitor := entry.Monitor
+	return &monitor, nil
+}
+
+// ListMonitors returns the active monitor subscriptions of client, oldest
+// first
+func ListMonitors(client string) []*Monitor {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+
+	var list []*Monitor
+	for _, entry := range monitors.monitors {
+		if entry.Client == client {
+			monitor := entry.Monitor
+			list = append(list, &monitor)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
@@ -0,0 +1,150 @@
+package flight
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/database"
+	"github.com/felixputera/cz4013-flight-info/server/rpc"
+)
+
+const (
+	stressSeats    = 500
+	stressRequests = 400
+)
+
+func TestConcurrentReserve(t *testing.T) {
+	stores := map[string]func(t *testing.T) FlightStore{
+		"memory": func(t *testing.T) FlightStore {
+			return NewMemoryStore()
+		},
+		"sqlite": func(t *testing.T) FlightStore {
+			db, err := database.Open(":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewSQLiteStore(db)
+			if err != nil {
+				db.Close()
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			defer s.Close()
+			Init(s)
+			testConcurrentReserve(t, s)
+		})
+	}
+}
+
+// testConcurrentReserve reserves more seats than the flight has from many
+// goroutines and checks that the flight is never oversold
+func testConcurrentReserve(t *testing.T, s FlightStore) {
+	flight := &Flight{
+		ID:            "SQ1",
+		From:          "SIN",
+		To:            "HND",
+		DepartureTime: time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC),
+		AvailabeSeats: stressSeats,
+		Fare:          100,
+	}
+	if err := s.CreateFlight(flight); err != nil {
+		t.Fatal(err)
+	}
+
+	processor := NewProcessor()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		refs     []string
+		reserved int32
+	)
+	for i := 0; i < stressRequests; i++ {
+		seats := int32(i%3 + 1)
+		wg.Add(1)
+		go func(seqID, seats int32) {
+			defer wg.Done()
+			ref, err := callReserve(processor, seqID, flight.ID, seats)
+			if err != nil {
+				if e, ok := err.(rpc.ApplicationException); !ok || e.TypeID() != InsufficientSeatsID {
+					t.Errorf("reserve %d seats: %v", seats, err)
+				}
+				return
+			}
+			mu.Lock()
+			refs = append(refs, ref)
+			reserved += seats
+			mu.Unlock()
+		}(int32(i), seats)
+	}
+	wg.Wait()
+
+	got, err := s.GetFlight(flight.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AvailabeSeats < 0 {
+		t.Fatalf("available seats went negative: %d", got.AvailabeSeats)
+	}
+	if got.AvailabeSeats+reserved != stressSeats {
+		t.Fatalf("%d seats left and %d reserved, want %d in total", got.AvailabeSeats, reserved, stressSeats)
+	}
+
+	var stored int32
+	for _, ref := range refs {
+		reservation, err := s.GetReservation(ref)
+		if err != nil {
+			t.Fatalf("reservation %s: %v", ref, err)
+		}
+		stored += reservation.Seats
+	}
+	if stored != reserved {
+		t.Fatalf("reservations hold %d seats, replies reserved %d", stored, reserved)
+	}
+}
+
+// callReserve sends a reserve call through the processor in memory and returns
+// the booking reference of the reply
+func callReserve(processor *Processor, seqID int32, id string, seats int32) (string, error) {
+	in, out := rpc.NewMemoryBuffer(), rpc.NewMemoryBuffer()
+	iprot, oprot := rpc.NewBinaryProtocol(in), rpc.NewBinaryProtocol(out)
+
+	if err := iprot.WriteMessageBegin("reserve", rpc.Call, seqID); err != nil {
+		return "", err
+	}
+	if err := (&reserveArgs{id: id, seats: seats}).write(iprot); err != nil {
+		return "", err
+	}
+	if err := iprot.WriteMessageEnd(); err != nil {
+		return "", err
+	}
+	// the exception of a failed call is read from the reply below
+	if _, err := processor.Process(context.Background(), iprot, oprot); err != nil {
+		if _, ok := err.(rpc.ApplicationException); !ok {
+			return "", err
+		}
+	}
+
+	_, typeID, _, err := oprot.ReadMessageBegin()
+	if err != nil {
+		return "", err
+	}
+	if typeID == rpc.Exception {
+		exc := rpc.NewApplicationException(rpc.UnknownApplicationExceptionID, "")
+		if err := exc.Read(oprot); err != nil {
+			return "", err
+		}
+		return "", exc
+	}
+	res := &reserveResult{}
+	if err := res.read(oprot); err != nil {
+		return "", err
+	}
+	return res.bookingRef, oprot.ReadMessageEnd()
+}
@@ -0,0 +1,234 @@
+package flight
+
+import (
+	"time"
+
+	"github.com/felixputera/cz4013-flight-info/server/database"
+	"github.com/jinzhu/gorm"
+)
+
+// SQLiteStore is a FlightStore keeping flights in a database through gorm
+type SQLiteStore struct {
+	db *gorm.DB
+}
+
+// NewSQLiteStore migrates the schema of db to the latest version if needed.
+// The store takes ownership of db and closes it on Close.
+func NewSQLiteStore(db *gorm.DB) (*SQLiteStore, error) {
+	if err := database.Migrate(db, Migrations, database.Latest); err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) GetFlight(id string) (*Flight, error) {
+	flight := new(Flight)
+	res := s.db.Where("id = ?", id).First(flight)
+	if res.RecordNotFound() {
+		return nil, ErrFlightNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return flight, nil
+}
+
+func (s *SQLiteStore) FindFlights(q *SearchQuery) ([]*Flight, error) {
+	// route, fare and seats are narrowed down by the database, departure times
+	// are compared here as sqlite compares timestamps with offsets as text
+	var candidates []*Flight
+	db := s.db.Where(&Flight{From: q.From, To: q.To})
+	if q.MaxFare > 0 {
+		db = db.Where("fare <= ?", q.MaxFare)
+	}
+	if q.MinSeats > 0 {
+		db = db.Where("availabe_seats >= ?", q.MinSeats)
+	}
+	if err := db.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	flights := make([]*Flight, 0, len(candidates))
+	for _, f := range candidates {
+		if q.match(f) {
+			flights = append(flights, f)
+		}
+	}
+	return flights, nil
+}
+
+func (s *SQLiteStore) CreateFlight(flight *Flight) error {
+	tx := s.db.Begin()
+	var count int
+	if err := tx.Model(&Flight{}).Where("id = ?", flight.ID).Count(&count).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return ErrDuplicateFlight
+	}
+	if err := tx.Create(flight).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *SQLiteStore) SaveFlights(flights []*Flight, replace bool) error {
+	tx := s.db.Begin()
+	for _, flight := range flights {
+		var count int
+		if err := tx.Model(&Flight{}).Where("id = ?", flight.ID).Count(&count).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		var err error
+		switch {
+		case count == 0:
+			err = tx.Create(flight).Error
+		case replace:
+			err = tx.Save(flight).Error
+		default:
+			err = prefixError(flight.ID, ErrDuplicateFlight)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func (s *SQLiteStore) UpdateFlight(id string, change func(flight *Flight, reserved bool) error) (*Flight, error) {
+	tx := s.db.Begin()
+	flight, reserved, err := s.reservedFlight(tx, id)
+	if err == nil {
+		err = change(flight, reserved)
+	}
+	if err == nil {
+		err = tx.Save(flight).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return flight, nil
+}
+
+func (s *SQLiteStore) DeleteFlight(id string) (*Flight, error) {
+	tx := s.db.Begin()
+	flight, reserved, err := s.reservedFlight(tx, id)
+	if err == nil && reserved {
+		err = ErrFlightReserved
+	}
+	if err == nil {
+		err = tx.Delete(flight).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return flight, nil
+}
+
+// reservedFlight reads a flight and whether it has active reservations in tx
+func (s *SQLiteStore) reservedFlight(tx *gorm.DB, id string) (*Flight, bool, error) {
+	flight := new(Flight)
+	res := tx.Where("id = ?", id).First(flight)
+	if res.RecordNotFound() {
+		return nil, false, ErrFlightNotFound
+	}
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	var count int
+	err := tx.Model(&Reservation{}).Where("flight_id = ? AND cancelled = ?", id, false).Count(&count).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return flight, count > 0, nil
+}
+
+// Reserve checks and decrements the seats by a single conditional update
+func (s *SQLiteStore) Reserve(reservation *Reservation) error {
+	tx := s.db.Begin()
+	res := tx.Model(&Flight{}).
+		Where("id = ? AND availabe_seats >= ?", reservation.FlightID, reservation.Seats).
+		UpdateColumn("availabe_seats", gorm.Expr("availabe_seats - ?", reservation.Seats))
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var count int
+		err := tx.Model(&Flight{}).Where("id = ?", reservation.FlightID).Count(&count).Error
+		tx.Rollback()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrFlightNotFound
+		}
+		return ErrNotEnoughSeats
+	}
+	if err := tx.Create(reservation).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *SQLiteStore) GetReservation(bookingRef string) (*Reservation, error) {
+	reservation := new(Reservation)
+	res := s.db.Where("id = ?", bookingRef).First(reservation)
+	if res.RecordNotFound() {
+		return nil, ErrReservationNotFound
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return reservation, nil
+}
+
+func (s *SQLiteStore) CancelReservation(bookingRef string, at time.Time) (*Reservation, bool, error) {
+	reservation, err := s.GetReservation(bookingRef)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx := s.db.Begin()
+	res := tx.Model(&Reservation{}).
+		Where("id = ? AND cancelled = ?", bookingRef, false).
+		Updates(map[string]interface{}{"cancelled": true, "cancelled_at": at})
+	if res.Error != nil {
+		tx.Rollback()
+		return nil, false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return reservation, false, nil
+	}
+	err = tx.Model(&Flight{}).
+		Where("id = ?", reservation.FlightID).
+		UpdateColumn("availabe_seats", gorm.Expr("availabe_seats + ?", reservation.Seats)).
+		Error
+	if err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, false, err
+	}
+	return reservation, true, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
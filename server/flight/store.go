@@ -0,0 +1,58 @@
+package flight
+
+import "time"
+
+// Errors returned by a FlightStore
+var (
+	ErrFlightNotFound      = &Error{FlightNotFoundID, "flight not found"}
+	ErrDuplicateFlight     = &Error{DuplicateFlightID, "duplicate flight number found"}
+	ErrNotEnoughSeats      = &Error{InsufficientSeatsID, "flight doesn't have enough available seats"}
+	ErrReservationNotFound = &Error{ReservationNotFoundID, "reservation not found"}
+	ErrFlightReserved      = &Error{FlightReservedID, "flight has active reservations"}
+)
+
+// FlightStore keeps the flights and their reservations. Implementations must
+// be safe for concurrent use, and must check and update the available seats
+// of a flight atomically so concurrent reservations never oversell it.
+type FlightStore interface {
+	// GetFlight returns the flight with the given ID or ErrFlightNotFound
+	GetFlight(id string) (*Flight, error)
+	// FindFlights returns the flights matching the filters of q, in no
+	// particular order. Sorting and pagination of q are ignored.
+	FindFlights(q *SearchQuery) ([]*Flight, error)
+	// CreateFlight adds a flight, failing with ErrDuplicateFlight if its ID is
+	// taken
+	CreateFlight(flight *Flight) error
+	// SaveFlights adds all flights or none. A flight with a taken ID replaces
+	// the existing one if replace is set, otherwise ErrDuplicateFlight is
+	// returned.
+	SaveFlights(flights []*Flight, replace bool) error
+	// UpdateFlight calls change with the flight and whether it has active
+	// reservations, and stores the changed flight unless change fails. The
+	// flight can't be reserved or changed by others in the meantime.
+	UpdateFlight(id string, change func(flight *Flight, reserved bool) error) (*Flight, error)
+	// DeleteFlight removes a flight, failing with ErrFlightReserved if it has
+	// active reservations. It returns the removed flight.
+	DeleteFlight(id string) (*Flight, error)
+
+	// Reserve takes the seats of the reservation from its flight and records
+	// the reservation, failing with ErrFlightNotFound or ErrNotEnoughSeats
+	Reserve(reservation *Reservation) error
+	// GetReservation returns the reservation with the given booking reference
+	// or ErrReservationNotFound
+	GetReservation(bookingRef string) (*Reservation, error)
+	// CancelReservation marks a reservation cancelled at the given time and
+	// returns its seats to the flight. It returns the reservation as stored
+	// before, and false if it was already cancelled and nothing changed.
+	CancelReservation(bookingRef string, at time.Time) (*Reservation, bool, error)
+
+	Close() error
+}
+
+var store FlightStore
+
+// Init sets the store used by the flight operations, it must be called before
+// serving requests
+func Init(s FlightStore) {
+	store = s
+}
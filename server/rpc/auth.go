@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxClockSkew is how far the timestamp of a signed request may be off
+// the server clock
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	KeyID string
+	Role  string
+}
+
+// Authenticator finds the principal of a request by its header. It returns a
+// nil principal without error for a request which isn't signed.
+type Authenticator interface {
+	Authenticate(hdr *RequestHeader) (*Principal, error)
+}
+
+// Key is a shared secret a client signs requests with, giving it a role
+type Key struct {
+	ID     string
+	Role   string
+	Secret []byte
+}
+
+// HMACAuthenticator verifies requests signed with HMAC-SHA256 by one of its
+// keys, see WriteSignedRequestHeader. A signed request is accepted once. A
+// retransmission of it can still get the cached reply, the server checks its
+// signature with VerifySignature before sending it.
+type HMACAuthenticator struct {
+	// MaxClockSkew is how far the timestamp of a request may be off the
+	// server clock, older requests are rejected as replays
+	MaxClockSkew time.Duration
+
+	keys map[string]*Key
+
+	// requests accepted within MaxClockSkew, created on first use
+	replayOnce sync.Once
+	replay     *replayWindow
+}
+
+func NewHMACAuthenticator(keys []*Key) *HMACAuthenticator {
+	a := &HMACAuthenticator{
+		MaxClockSkew: DefaultMaxClockSkew,
+		keys:         make(map[string]*Key),
+	}
+	for _, key := range keys {
+		a.keys[key.ID] = key
+	}
+	return a
+}
+
+func (a *HMACAuthenticator) Authenticate(hdr *RequestHeader) (*Principal, error) {
+	if hdr == nil || hdr.KeyID == "" {
+		return nil, nil
+	}
+	key, err := a.verify(hdr)
+	if err != nil {
+		return nil, err
+	}
+	signedAt := time.Unix(0, hdr.Timestamp*int64(time.Millisecond))
+	skew := time.Since(signedAt)
+	if skew > a.MaxClockSkew || skew < -a.MaxClockSkew {
+		return nil, errors.New("request timestamp out of range")
+	}
+
+	a.replayOnce.Do(func() { a.replay = newReplayWindow(a.MaxClockSkew) })
+	if err := a.replay.check(signedAt, signedRequestID(hdr)); err != nil {
+		return nil, err
+	}
+	return &Principal{KeyID: key.ID, Role: key.Role}, nil
+}
+
+// VerifySignature checks the signature of a signed request without accepting
+// it, a retransmission passes as long as the key is known
+func (a *HMACAuthenticator) VerifySignature(hdr *RequestHeader) error {
+	_, err := a.verify(hdr)
+	return err
+}
+
+func (a *HMACAuthenticator) verify(hdr *RequestHeader) (*Key, error) {
+	key, ok := a.keys[hdr.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", hdr.KeyID)
+	}
+	if !hmac.Equal(hdr.Signature, sign(key.Secret, hdr.signed...)) {
+		return nil, errors.New("invalid signature")
+	}
+	return key, nil
+}
+
+// signedRequestID identifies a signed request to detect its replays
+func signedRequestID(hdr *RequestHeader) string {
+	sep := string(MapKeySeparator)
+	return hdr.KeyID + sep + hdr.ClientID + sep + strconv.FormatInt(hdr.RequestID, 10)
+}
+
+// LoadKeys reads the keys of a key file. Every line holds the ID, role and
+// hex encoded secret of a key separated by spaces, empty lines and lines
+// starting with # are skipped.
+func LoadKeys(path string) ([]*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []*Key
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected key ID, role and secret", path, n)
+		}
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("%s:%d: secret is not hex encoded", path, n)
+		}
+		if seen[fields[0]] {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %q", path, n, fields[0])
+		}
+		seen[fields[0]] = true
+		keys = append(keys, &Key{ID: fields[0], Role: fields[1], Secret: secret})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AuthResult is the outcome of authenticating a request. Principal is nil for
+// an unsigned request or if Err is set.
+type AuthResult struct {
+	Principal *Principal
+	Err       error
+}
+
+type authResultKey struct{}
+
+// WithAuthResult returns a context carrying the authentication of a request
+func WithAuthResult(ctx context.Context, result *AuthResult) context.Context {
+	return context.WithValue(ctx, authResultKey{}, func() *AuthResult { return result })
+}
+
+// withAuthentication returns a context authenticating a request with
+// authenticate when its authentication is first asked for
+func withAuthentication(ctx context.Context, authenticate func() *AuthResult) context.Context {
+	var once sync.Once
+	var result *AuthResult
+	return context.WithValue(ctx, authResultKey{}, func() *AuthResult {
+		once.Do(func() { result = authenticate() })
+		return result
+	})
+}
+
+// AuthResultFromContext returns the authentication of the request being
+// processed, nil if the server doesn't authenticate requests
+func AuthResultFromContext(ctx context.Context) *AuthResult {
+	result, _ := ctx.Value(authResultKey{}).(func() *AuthResult)
+	if result == nil {
+		return nil
+	}
+	return result()
+}
@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// signedRequest returns a request with body signed with key by a header of
+// the given request ID
+func signedRequest(t *testing.T, key *Key, requestID int64, body []byte) []byte {
+	buf := new(bytes.Buffer)
+	hdr := &RequestHeader{
+		ClientID:  "client",
+		RequestID: requestID,
+		KeyID:     key.ID,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if err := WriteSignedRequestHeader(buf, hdr, key.Secret, body); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func authenticate(a Authenticator, req []byte) (*Principal, error) {
+	hdr, _, err := ReadRequestHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	return a.Authenticate(hdr)
+}
+
+func TestHMACAuthenticatorRejectsReplay(t *testing.T) {
+	key := &Key{ID: "admin-1", Role: "admin", Secret: []byte("secret")}
+	a := NewHMACAuthenticator([]*Key{key})
+
+	req := signedRequest(t, key, 1, []byte("deleteFlight"))
+	principal, err := authenticate(a, req)
+	if err != nil || principal == nil || principal.Role != "admin" {
+		t.Fatalf("first delivery authenticated as %+v, %v", principal, err)
+	}
+	if principal, err := authenticate(a, req); err == nil {
+		t.Fatalf("replayed request authenticated as %+v", principal)
+	}
+
+	// a new request of the same client is accepted
+	if _, err := authenticate(a, signedRequest(t, key, 2, []byte("deleteFlight"))); err != nil {
+		t.Fatalf("next request: %v", err)
+	}
+}
+
+func TestRequestKeyIncludesSignature(t *testing.T) {
+	key := &Key{ID: "admin-1", Role: "admin", Secret: []byte("secret")}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	requestKey := func(req []byte) string {
+		hdr, _, err := ReadRequestHeader(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return RequestKey(addr, hdr, req)
+	}
+
+	req := signedRequest(t, key, 1, []byte("deleteFlight"))
+	if requestKey(req) != requestKey(req) {
+		t.Fatal("retransmission has another key")
+	}
+	forged := append([]byte(nil), req...)
+	forged[len(forged)-1] ^= 1
+	forgedHdr, _, _ := ReadRequestHeader(forged)
+	forgedHdr.Signature = []byte("forged")
+	if RequestKey(addr, forgedHdr, forged) == requestKey(req) {
+		t.Fatal("request with another signature has the same key")
+	}
+}
+
+// duplicateTransport is a request answered by a cached reply, what's read and
+// written goes through the same buffer
+type duplicateTransport struct {
+	*MemoryBuffer
+	hdr     *RequestHeader
+	reply   []byte
+	aborted bool
+}
+
+func newDuplicateTransport(t *testing.T, req, reply []byte) *duplicateTransport {
+	hdr, body, err := ReadRequestHeader(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &duplicateTransport{MemoryBuffer: NewMemoryBufferWithData(body), hdr: hdr, reply: reply}
+}
+
+func (p *duplicateTransport) Header() *RequestHeader { return p.hdr }
+func (p *duplicateTransport) CachedReply() []byte    { return p.reply }
+func (p *duplicateTransport) Abort()                 { p.aborted = true }
+
+type processorFunc func(ctx context.Context, iprot, oprot Protocol) (bool, error)
+
+func (f processorFunc) Process(ctx context.Context, iprot, oprot Protocol) (bool, error) {
+	return f(ctx, iprot, oprot)
+}
+
+func TestCachedReplyRequiresSignature(t *testing.T) {
+	key := &Key{ID: "admin-1", Role: "admin", Secret: []byte("secret")}
+	server := NewUdpServer(processorFunc(func(context.Context, Protocol, Protocol) (bool, error) {
+		t.Fatal("duplicate request processed")
+		return false, nil
+	}), nil, NewTransportFactory(), NewBinaryProtocolFactory())
+	server.SetAuthenticator(NewHMACAuthenticator([]*Key{key}))
+
+	req := signedRequest(t, key, 1, []byte("deleteFlight"))
+	client := newDuplicateTransport(t, req, []byte("reply"))
+	if err := server.processRequests(client); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Bytes(); string(got) != "reply" {
+		t.Fatalf("retransmission answered with %q", got)
+	}
+
+	// the header of the request with another body
+	forged := append([]byte(nil), req...)
+	forged[len(forged)-1] ^= 1
+	client = newDuplicateTransport(t, forged, []byte("reply"))
+	if err := server.processRequests(client); err != nil {
+		t.Fatal(err)
+	}
+	if client.Len() != 0 {
+		t.Fatalf("forged request answered with %q", client.Bytes())
+	}
+}
+
+func TestFailedAuthenticationNotCached(t *testing.T) {
+	key := &Key{ID: "admin-1", Role: "admin", Secret: []byte("secret")}
+	var result *AuthResult
+	server := NewUdpServer(processorFunc(func(ctx context.Context, iprot, oprot Protocol) (bool, error) {
+		result = AuthResultFromContext(ctx)
+		return true, nil
+	}), nil, NewTransportFactory(), NewBinaryProtocolFactory())
+	server.SetAuthenticator(NewHMACAuthenticator([]*Key{key}))
+
+	req := signedRequest(t, key, 1, []byte("deleteFlight"))
+	req[len(req)-1] ^= 1
+	client := newDuplicateTransport(t, req, nil)
+	if err := server.processRequests(client); err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.Err == nil {
+		t.Fatalf("forged request authenticated as %+v", result)
+	}
+	if !client.aborted {
+		t.Fatal("reply to forged request left in the reply cache")
+	}
+}
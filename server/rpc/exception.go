@@ -87,6 +87,9 @@ const (
 	MissingResultID               = 5
 	InternalErrorID               = 6
 	ProtocolErrorID               = 7
+	// IDs 8 to 10 are used by Thrift
+	UnauthenticatedID  = 11
+	PermissionDeniedID = 12
 )
 
 var defaultApplicationExceptionMessage = map[int32]string{
@@ -98,6 +101,8 @@ var defaultApplicationExceptionMessage = map[int32]string{
 	MissingResultID:               "missing result",
 	InternalErrorID:               "unknown internal error",
 	ProtocolErrorID:               "unknown protocol error",
+	UnauthenticatedID:             "unauthenticated",
+	PermissionDeniedID:            "permission denied",
 }
 
 type ApplicationException interface {
@@ -145,6 +150,20 @@ func (p *applicationException) Read(iprot Protocol) error {
 				if message, err = iprot.ReadString(); err != nil {
 					return err
 				}
+			} else if err := Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		case 2:
+			if fieldType == I32 {
+				if typeID, err = iprot.ReadI32(); err != nil {
+					return err
+				}
+			} else if err := Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		default:
+			if err := Skip(iprot, fieldType); err != nil {
+				return err
 			}
 		}
 	}
@@ -167,6 +186,15 @@ func (p *applicationException) Write(oprot Protocol) (err error) {
 			return
 		}
 	}
+	if err = oprot.WriteFieldBegin("type", I32, 2); err != nil {
+		return
+	}
+	if err = oprot.WriteI32(p.typeID); err != nil {
+		return
+	}
+	if err = oprot.WriteFieldEnd(); err != nil {
+		return
+	}
 	err = oprot.WriteFieldStop()
 	if err != nil {
 		return
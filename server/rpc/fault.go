@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"bytes"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultConfig describes the network faults simulated by a fault injecting
+// transport. Probabilities are between 0 and 1.
+type FaultConfig struct {
+	// RequestDrop is the probability of a request being lost before processing
+	RequestDrop float64
+	// ReplyDrop is the probability of a reply being lost after processing
+	ReplyDrop float64
+	// Delay is added to every reply, with a random extra of up to Jitter
+	Delay  time.Duration
+	Jitter time.Duration
+	// Duplicate is the probability of a reply being delivered twice
+	Duplicate float64
+	// Reorder is the probability of a reply being held back for ReorderDelay,
+	// letting replies sent after it overtake it
+	Reorder      float64
+	ReorderDelay time.Duration
+	// Seed of the random generator, the same seed gives the same faults for
+	// the same sequence of requests
+	Seed int64
+}
+
+// Enabled returns true if the config simulates any fault
+func (c FaultConfig) Enabled() bool {
+	return c.RequestDrop > 0 || c.ReplyDrop > 0 || c.Delay > 0 || c.Jitter > 0 ||
+		c.Duplicate > 0 || c.Reorder > 0
+}
+
+// discarder is implemented by transports which can drop a pending reply while
+// still accounting for it as sent, see UDPSocket.Discard
+type discarder interface {
+	Discard() error
+}
+
+type faultTransportFactory struct {
+	factory TransportFactory
+	config  FaultConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewFaultTransportFactory wraps the transports of factory to simulate the
+// faults of a lossy network
+func NewFaultTransportFactory(factory TransportFactory, config FaultConfig) TransportFactory {
+	return &faultTransportFactory{
+		factory: factory,
+		config:  config,
+		rng:     rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func (p *faultTransportFactory) GetTransport(trans Transport) (Transport, error) {
+	inner, err := p.factory.GetTransport(trans)
+	if err != nil {
+		return nil, err
+	}
+	return &faultTransport{Transport: inner, factory: p}, nil
+}
+
+// WrapCachedReply applies the faults to a cached reply sent through trans.
+// The reply is passed on as it is unless the wrapped factory can wrap it too.
+func (p *faultTransportFactory) WrapCachedReply(trans Transport) Transport {
+	if w, ok := p.factory.(cachedReplyWrapper); ok {
+		trans = w.WrapCachedReply(trans)
+	}
+	return &faultTransport{Transport: trans, factory: p, cachedReply: true}
+}
+
+func (p *faultTransportFactory) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rng.Float64() < probability
+}
+
+func (p *faultTransportFactory) replyDelay() time.Duration {
+	delay := p.config.Delay
+	if p.config.Jitter > 0 {
+		p.mu.Lock()
+		delay += time.Duration(p.rng.Int63n(int64(p.config.Jitter)))
+		p.mu.Unlock()
+	}
+	if p.chance(p.config.Reorder) {
+		delay += p.config.ReorderDelay
+	}
+	return delay
+}
+
+// faultTransport applies the faults of its factory to the wrapped transport.
+// Whether the request is lost is decided on the first read, replies are
+// buffered until Flush decides their fate.
+type faultTransport struct {
+	Transport
+	factory *faultTransportFactory
+
+	readStarted    bool
+	requestDropped bool
+	writebuf       bytes.Buffer
+
+	// the duplicate request answered by a cached reply is never read
+	cachedReply bool
+}
+
+var errRequestDropped = NewTransportException(EndOfFileID, "request dropped by fault injection")
+
+func (p *faultTransport) checkRequestDropped() error {
+	if !p.readStarted {
+		p.readStarted = true
+		if p.factory.chance(p.factory.config.RequestDrop) {
+			log.Println("fault injection: dropping request from", p.Address())
+			p.requestDropped = true
+		}
+	}
+	if p.requestDropped {
+		return errRequestDropped
+	}
+	return nil
+}
+
+func (p *faultTransport) Read(buf []byte) (int, error) {
+	if err := p.checkRequestDropped(); err != nil {
+		return 0, err
+	}
+	return p.Transport.Read(buf)
+}
+
+func (p *faultTransport) Write(buf []byte) (int, error) {
+	return p.writebuf.Write(buf)
+}
+
+func (p *faultTransport) Flush() error {
+	reply := make([]byte, p.writebuf.Len())
+	copy(reply, p.writebuf.Bytes())
+	p.writebuf.Reset()
+
+	if p.cachedReply {
+		// the duplicate request can be lost like any other
+		if err := p.checkRequestDropped(); err != nil {
+			return err
+		}
+	}
+
+	if delay := p.factory.replyDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if p.factory.chance(p.factory.config.ReplyDrop) {
+		log.Println("fault injection: dropping reply to", p.Address())
+		if d, ok := p.Transport.(discarder); ok {
+			if _, err := p.Transport.Write(reply); err != nil {
+				return err
+			}
+			return d.Discard()
+		}
+		return nil
+	}
+
+	if err := p.send(reply); err != nil {
+		return err
+	}
+	if p.factory.chance(p.factory.config.Duplicate) {
+		log.Println("fault injection: duplicating reply to", p.Address())
+		return p.send(reply)
+	}
+	return nil
+}
+
+func (p *faultTransport) send(reply []byte) error {
+	if _, err := p.Transport.Write(reply); err != nil {
+		return err
+	}
+	return p.Transport.Flush()
+}
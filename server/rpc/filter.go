@@ -1,57 +1,218 @@
 package rpc
 
 import (
+	"encoding/binary"
+	"log"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/golang-lru/simplelru"
 )
 
-var (
-	cache           *lru.Cache
-	CacheSize       = 128
-	MapKeySeparator = []byte{255}
+const (
+	// DefaultCacheSize is the default number of replies kept by a ReplyCache
+	DefaultCacheSize = 128
+	// DefaultCacheTTL is the default time a reply is kept by a ReplyCache
+	DefaultCacheTTL = 5 * time.Minute
+
+	// pendingTimeout bounds how long a request is treated as in progress. A
+	// request which never produced a reply is processed again afterwards.
+	pendingTimeout = 10 * time.Second
+)
+
+var MapKeySeparator = []byte{255}
+
+// CacheStatus is the outcome of looking up a request in a ReplyCache
+type CacheStatus int
+
+const (
+	// CacheMiss means the request is new and has to be processed
+	CacheMiss CacheStatus = iota
+	// CacheHit means the request was processed before and its reply is cached
+	CacheHit
+	// CacheInProgress means the request is still being processed
+	CacheInProgress
 )
 
-func GetComputedResult(addr net.Addr, req []byte) ([]byte, bool) {
-	// create cache if not initialized yet
-	if cache == nil {
-		var err error
-		cache, err = lru.New(CacheSize)
-		if err != nil {
-			panic(err)
+// CacheStats counts the lookups and removals of a ReplyCache
+type CacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	InProgress  uint64
+	Evictions   uint64
+	Expirations uint64
+	Size        int
+}
+
+type cacheEntry struct {
+	reply   []byte
+	done    bool
+	created time.Time
+}
+
+// ReplyCache gives at-most-once semantics to requests by remembering the reply
+// sent for each request. Entries are dropped when they are older than the TTL
+// or, once the cache is full, in least recently used order.
+type ReplyCache struct {
+	mu    sync.Mutex
+	lru   *simplelru.LRU
+	ttl   time.Duration
+	stats CacheStats
+}
+
+// NewReplyCache creates a reply cache holding at most size replies for ttl each
+func NewReplyCache(size int, ttl time.Duration) (*ReplyCache, error) {
+	c := &ReplyCache{ttl: ttl}
+	l, err := simplelru.NewLRU(size, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = l
+	return c, nil
+}
+
+// onEvict is called with the lock held whenever an entry leaves the cache
+func (c *ReplyCache) onEvict(key, value interface{}) {
+	if c.expired(value.(*cacheEntry), time.Now()) {
+		c.stats.Expirations++
+	} else {
+		c.stats.Evictions++
+	}
+}
+
+func (c *ReplyCache) expired(entry *cacheEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(entry.created) > c.ttl
+}
+
+// Begin looks up a request. On a miss the request is marked as in progress
+// until its reply is stored with Put, on a hit the cached reply is returned.
+func (c *ReplyCache) Begin(key string) ([]byte, CacheStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if val, ok := c.lru.Get(key); ok {
+		entry := val.(*cacheEntry)
+		switch {
+		case c.expired(entry, now):
+			c.lru.Remove(key)
+		case entry.done:
+			c.stats.Hits++
+			return entry.reply, CacheHit
+		case now.Sub(entry.created) < pendingTimeout:
+			c.stats.InProgress++
+			return nil, CacheInProgress
 		}
 	}
 
-	key := combineAddrSeqID(addr, req)
+	c.stats.Misses++
+	c.lru.Add(key, &cacheEntry{created: now})
+	return nil, CacheMiss
+}
+
+// Put stores the reply of a request. Only the first reply is kept, later
+// messages sent for the same request, like the updates of a stream, neither
+// replace it nor extend its TTL.
+func (c *ReplyCache) Put(key string, reply []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if val, ok := cache.Get(key); ok {
-		return val.([]byte), true
+	if val, ok := c.lru.Peek(key); ok && val.(*cacheEntry).done {
+		return
 	}
-	return nil, false
+	saved := make([]byte, len(reply))
+	copy(saved, reply)
+	c.lru.Add(key, &cacheEntry{reply: saved, done: true, created: time.Now()})
 }
 
-func PutComputedResult(addr net.Addr, req []byte, result []byte) {
-	// create cache if not initialized yet
-	if cache == nil {
-		var err error
-		cache, err = lru.New(CacheSize)
-		if err != nil {
-			panic(err)
-		}
+// Abort forgets a request which is in progress, so it's processed again when
+// retried. Requests which already have a reply are kept.
+func (c *ReplyCache) Abort(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if val, ok := c.lru.Peek(key); ok && !val.(*cacheEntry).done {
+		c.lru.Remove(key)
 	}
+}
 
-	key := combineAddrSeqID(addr, req)
+// Stats returns a snapshot of the cache counters
+func (c *ReplyCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	cache.Add(key, result)
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
 }
 
-func combineAddrSeqID(addr net.Addr, req []byte) string {
+// RequestKey identifies a request in a ReplyCache. Requests with a header are
+// identified by client and request ID, and the key and signature of a signed
+// request so a request which isn't signed the same can't get its reply or
+// take its place. Others are identified by their address and content.
+func RequestKey(addr net.Addr, hdr *RequestHeader, req []byte) string {
 	strBuilder := new(strings.Builder)
 
+	if hdr != nil {
+		strBuilder.WriteString(hdr.ClientID)
+		strBuilder.WriteString(string(MapKeySeparator))
+		strBuilder.WriteString(strconv.FormatInt(hdr.RequestID, 10))
+		if hdr.KeyID != "" {
+			strBuilder.WriteString(string(MapKeySeparator))
+			strBuilder.WriteString(hdr.KeyID)
+			strBuilder.WriteString(string(MapKeySeparator))
+			strBuilder.Write(hdr.Signature)
+		}
+		return strBuilder.String()
+	}
+
 	strBuilder.WriteString(addr.String())
 	strBuilder.WriteString(string(MapKeySeparator))
 	strBuilder.WriteString(string(req))
 
 	return strBuilder.String()
 }
+
+// expectsReply returns false if the message body is a one-way call, which
+// leaves no reply to cache. It only peeks at the message header and returns
+// true for anything it can't make sense of.
+func expectsReply(body []byte) bool {
+	if isSealed(body) {
+		return MessageType(body[4]) != Oneway
+	}
+	typeID, ok := peekMessageType(body)
+	return !ok || typeID != Oneway
+}
+
+// peekMessageType returns the type of a message encoded by BinaryProtocol
+// without decoding the rest of it
+func peekMessageType(body []byte) (MessageType, bool) {
+	if len(body) < 4 {
+		return 0, false
+	}
+	size := int(int32(binary.BigEndian.Uint32(body)))
+	if size < 0 || 4+size >= len(body) {
+		return 0, false
+	}
+	return MessageType(body[4+size]), true
+}
+
+// filterRequest looks up a request received from addr in the cache. It
+// returns the key to save the reply under, the cached reply of a request
+// processed before and whether the request has to be processed.
+func filterRequest(cache *ReplyCache, addr net.Addr, hdr *RequestHeader, req []byte) (string, []byte, bool) {
+	key := RequestKey(addr, hdr, req)
+	result, status := cache.Begin(key)
+	switch status {
+	case CacheHit:
+		log.Println("returning previous computed result")
+		return key, result, false
+	case CacheInProgress:
+		log.Println("dropping duplicate of request in progress")
+		return key, nil, false
+	}
+	return key, nil, true
+}
@@ -0,0 +1,105 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplyCacheLookups(t *testing.T) {
+	c, err := NewReplyCache(8, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status := c.Begin("a"); status != CacheMiss {
+		t.Fatalf("first lookup is %v, want a miss", status)
+	}
+	if _, status := c.Begin("a"); status != CacheInProgress {
+		t.Fatalf("lookup before the reply is %v, want in progress", status)
+	}
+	c.Put("a", []byte("reply"))
+	reply, status := c.Begin("a")
+	if status != CacheHit || string(reply) != "reply" {
+		t.Fatalf("lookup after the reply is %v %q, want a hit", status, reply)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.InProgress != 1 || stats.Size != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestReplyCachePutCopiesReply(t *testing.T) {
+	c, _ := NewReplyCache(8, time.Minute)
+	c.Begin("a")
+	buf := []byte("reply")
+	c.Put("a", buf)
+	copy(buf, "xxxxx")
+	if reply, _ := c.Begin("a"); string(reply) != "reply" {
+		t.Fatalf("cached reply changed to %q", reply)
+	}
+}
+
+func TestReplyCacheKeepsFirstReply(t *testing.T) {
+	c, _ := NewReplyCache(8, 50*time.Millisecond)
+	c.Begin("a")
+	c.Put("a", []byte("reply"))
+	time.Sleep(30 * time.Millisecond)
+	c.Put("a", []byte("update"))
+
+	if reply, _ := c.Begin("a"); string(reply) != "reply" {
+		t.Fatalf("cached reply replaced by %q", reply)
+	}
+	// the TTL still counts from the first reply
+	time.Sleep(30 * time.Millisecond)
+	if _, status := c.Begin("a"); status != CacheMiss {
+		t.Fatalf("lookup after the TTL of the first reply is %v, want a miss", status)
+	}
+}
+
+func TestReplyCacheExpiry(t *testing.T) {
+	c, _ := NewReplyCache(8, 20*time.Millisecond)
+	c.Begin("a")
+	c.Put("a", []byte("reply"))
+	time.Sleep(30 * time.Millisecond)
+
+	if _, status := c.Begin("a"); status != CacheMiss {
+		t.Fatalf("lookup of expired reply is %v, want a miss", status)
+	}
+	if stats := c.Stats(); stats.Expirations != 1 || stats.Evictions != 0 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestReplyCacheEviction(t *testing.T) {
+	c, _ := NewReplyCache(2, time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Begin(key)
+		c.Put(key, []byte(key))
+	}
+	stats := c.Stats()
+	if stats.Evictions != 1 || stats.Expirations != 0 || stats.Size != 2 {
+		t.Fatalf("stats %+v", stats)
+	}
+	// the least recently used reply is gone
+	if _, status := c.Begin("a"); status != CacheMiss {
+		t.Fatalf("lookup of evicted reply is %v, want a miss", status)
+	}
+}
+
+func TestReplyCacheAbort(t *testing.T) {
+	c, _ := NewReplyCache(8, time.Minute)
+
+	c.Begin("pending")
+	c.Abort("pending")
+	if _, status := c.Begin("pending"); status != CacheMiss {
+		t.Fatalf("lookup of aborted request is %v, want a miss", status)
+	}
+
+	c.Begin("done")
+	c.Put("done", []byte("reply"))
+	c.Abort("done")
+	if _, status := c.Begin("done"); status != CacheHit {
+		t.Fatalf("lookup of answered request after abort is %v, want a hit", status)
+	}
+}
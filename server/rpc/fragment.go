@@ -0,0 +1,361 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// FragmentMagic starts a datagram carrying a fragment of a message longer than
+// MaxBufferSize, or a request to retransmit missing fragments. Like
+// RequestHeaderMagic its high bit is set, so it can't be mistaken for the
+// start of a plain message.
+const FragmentMagic uint32 = 0x80A70002
+
+const (
+	fragmentData byte = 1
+	fragmentNack byte = 2
+
+	// magic, kind and message ID, followed by the index and total count of a
+	// data fragment
+	fragmentHeaderSize = 4 + 1 + 8 + 2 + 2
+
+	// FragmentPayloadSize is the number of message bytes carried by a fragment
+	FragmentPayloadSize = MaxBufferSize - fragmentHeaderSize
+	// MaxMessageSize is the length of the longest message sent in fragments
+	MaxMessageSize = 64 * 1024
+	// MaxFragments is the number of fragments of a message of MaxMessageSize
+	MaxFragments = (MaxMessageSize + FragmentPayloadSize - 1) / FragmentPayloadSize
+)
+
+var (
+	// how long fragments of an incomplete message are kept
+	reassemblyTimeout = 30 * time.Second
+	// how long to wait for a missing fragment before asking for it again
+	fragmentNackInterval = 100 * time.Millisecond
+	// how many times missing fragments are asked for before giving up
+	maxFragmentNacks = 3
+	// number of incomplete messages kept per sender and in total, the oldest
+	// are dropped first
+	maxPartialPerAddr  = 8
+	maxPartialMessages = 256
+
+	// how long sent fragments are kept for retransmission
+	sentFragmentsTTL = 30 * time.Second
+	// number of fragmented messages kept for retransmission
+	sentFragmentsSize = 256
+)
+
+var lastMessageID = uint64(time.Now().UnixNano())
+
+func nextMessageID() uint64 {
+	return atomic.AddUint64(&lastMessageID, 1)
+}
+
+// splitMessage splits msg into data fragment datagrams. A message fitting in a
+// single datagram is returned as is.
+func splitMessage(msg []byte) ([][]byte, uint64, error) {
+	if len(msg) <= MaxBufferSize {
+		return [][]byte{msg}, 0, nil
+	}
+	if len(msg) > MaxMessageSize {
+		return nil, 0, NewTransportException(UnknownTransportExceptionID,
+			fmt.Sprintf("message of %d bytes exceeds maximum size", len(msg)))
+	}
+	total := (len(msg) + FragmentPayloadSize - 1) / FragmentPayloadSize
+
+	messageID := nextMessageID()
+	datagrams := make([][]byte, total)
+	for i := range datagrams {
+		payload := msg[i*FragmentPayloadSize:]
+		if len(payload) > FragmentPayloadSize {
+			payload = payload[:FragmentPayloadSize]
+		}
+		buf := make([]byte, fragmentHeaderSize+len(payload))
+		binary.BigEndian.PutUint32(buf, FragmentMagic)
+		buf[4] = fragmentData
+		binary.BigEndian.PutUint64(buf[5:], messageID)
+		binary.BigEndian.PutUint16(buf[13:], uint16(i))
+		binary.BigEndian.PutUint16(buf[15:], uint16(total))
+		copy(buf[fragmentHeaderSize:], payload)
+		datagrams[i] = buf
+	}
+	return datagrams, messageID, nil
+}
+
+// fragment is a parsed fragment datagram, missing is only set for a request to
+// retransmit fragments
+type fragment struct {
+	kind      byte
+	messageID uint64
+	index     int
+	total     int
+	payload   []byte
+	missing   []int
+}
+
+var errMalformedFragment = NewProtocolExceptionWithType(InvalidDataID, fmt.Errorf("malformed fragment"))
+
+func isFragment(buf []byte) bool {
+	return len(buf) >= 4 && binary.BigEndian.Uint32(buf) == FragmentMagic
+}
+
+func parseFragment(buf []byte) (*fragment, error) {
+	if len(buf) < 4+1+8 {
+		return nil, errMalformedFragment
+	}
+	f := &fragment{kind: buf[4], messageID: binary.BigEndian.Uint64(buf[5:])}
+	buf = buf[13:]
+
+	switch f.kind {
+	case fragmentData:
+		if len(buf) < 4 {
+			return nil, errMalformedFragment
+		}
+		f.index = int(binary.BigEndian.Uint16(buf))
+		f.total = int(binary.BigEndian.Uint16(buf[2:]))
+		f.payload = buf[4:]
+		if f.total == 0 || f.total > MaxFragments || f.index >= f.total {
+			return nil, errMalformedFragment
+		}
+	case fragmentNack:
+		if len(buf) < 2 {
+			return nil, errMalformedFragment
+		}
+		count := int(binary.BigEndian.Uint16(buf))
+		buf = buf[2:]
+		if len(buf) < 2*count {
+			return nil, errMalformedFragment
+		}
+		for i := 0; i < count; i++ {
+			f.missing = append(f.missing, int(binary.BigEndian.Uint16(buf[2*i:])))
+		}
+	default:
+		return nil, errMalformedFragment
+	}
+	return f, nil
+}
+
+// nackDatagram asks the sender of a message to retransmit missing fragments
+func nackDatagram(messageID uint64, missing []int) []byte {
+	// as many indices as fit in a datagram, the rest is asked for later
+	if max := (MaxBufferSize - 4 - 1 - 8 - 2) / 2; len(missing) > max {
+		missing = missing[:max]
+	}
+	buf := make([]byte, 4+1+8+2+2*len(missing))
+	binary.BigEndian.PutUint32(buf, FragmentMagic)
+	buf[4] = fragmentNack
+	binary.BigEndian.PutUint64(buf[5:], messageID)
+	binary.BigEndian.PutUint16(buf[13:], uint16(len(missing)))
+	for i, index := range missing {
+		binary.BigEndian.PutUint16(buf[15+2*i:], uint16(index))
+	}
+	return buf
+}
+
+type partialMessage struct {
+	addr      net.Addr
+	messageID uint64
+	fragments [][]byte
+	received  int
+	created   time.Time
+	updated   time.Time
+	nacks     int
+}
+
+func (m *partialMessage) missing() []int {
+	var missing []int
+	for i, payload := range m.fragments {
+		if payload == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// reassembler collects the fragments of messages until they are complete.
+// The number of incomplete messages is bounded, so a sender can't exhaust
+// memory with messages it never completes.
+type reassembler struct {
+	partial map[string]*partialMessage
+	// number of incomplete messages by sender address
+	perAddr map[string]int
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		partial: make(map[string]*partialMessage),
+		perAddr: make(map[string]int),
+	}
+}
+
+// add stores a data fragment, returning the whole message once all of its
+// fragments were received
+func (r *reassembler) add(addr net.Addr, f *fragment) []byte {
+	now := time.Now()
+	r.expire(now)
+
+	key := fmt.Sprintf("%s%s%d", addr, MapKeySeparator, f.messageID)
+	m, ok := r.partial[key]
+	if !ok {
+		r.makeRoom(addr)
+		m = &partialMessage{addr: addr, messageID: f.messageID, fragments: make([][]byte, f.total), created: now}
+		r.partial[key] = m
+		r.perAddr[addr.String()]++
+	}
+	if len(m.fragments) != f.total || m.fragments[f.index] != nil {
+		// inconsistent or duplicate fragment
+		return nil
+	}
+	payload := make([]byte, len(f.payload))
+	copy(payload, f.payload)
+	m.fragments[f.index] = payload
+	m.received++
+	m.updated = now
+	m.nacks = 0
+
+	if m.received < len(m.fragments) {
+		return nil
+	}
+	r.remove(key)
+	var msg []byte
+	for _, payload := range m.fragments {
+		msg = append(msg, payload...)
+	}
+	return msg
+}
+
+// stalled returns the incomplete messages which received no fragment for
+// fragmentNackInterval, counting them as asked for again. Messages asked for
+// too often are dropped.
+func (r *reassembler) stalled() []*partialMessage {
+	now := time.Now()
+	r.expire(now)
+
+	var stalled []*partialMessage
+	for key, m := range r.partial {
+		if now.Sub(m.updated) < fragmentNackInterval {
+			continue
+		}
+		if m.nacks >= maxFragmentNacks {
+			r.remove(key)
+			continue
+		}
+		m.nacks++
+		m.updated = now
+		stalled = append(stalled, m)
+	}
+	return stalled
+}
+
+// pending returns true if a message is being reassembled
+func (r *reassembler) pending() bool {
+	return len(r.partial) > 0
+}
+
+func (r *reassembler) expire(now time.Time) {
+	for key, m := range r.partial {
+		if now.Sub(m.updated) > reassemblyTimeout {
+			r.remove(key)
+		}
+	}
+}
+
+// makeRoom drops the oldest incomplete messages before a new message from addr
+// is added, if the limits of addr or of all senders are reached
+func (r *reassembler) makeRoom(addr net.Addr) {
+	if r.perAddr[addr.String()] >= maxPartialPerAddr {
+		r.dropOldest(func(m *partialMessage) bool { return m.addr.String() == addr.String() })
+	}
+	if len(r.partial) >= maxPartialMessages {
+		r.dropOldest(func(*partialMessage) bool { return true })
+	}
+}
+
+// dropOldest removes the incomplete message received first among those match
+// returns true for
+func (r *reassembler) dropOldest(match func(m *partialMessage) bool) {
+	var oldestKey string
+	var oldest *partialMessage
+	for key, m := range r.partial {
+		if match(m) && (oldest == nil || m.created.Before(oldest.created)) {
+			oldestKey, oldest = key, m
+		}
+	}
+	if oldest != nil {
+		r.remove(oldestKey)
+	}
+}
+
+func (r *reassembler) remove(key string) {
+	m, ok := r.partial[key]
+	if !ok {
+		return
+	}
+	delete(r.partial, key)
+	addr := m.addr.String()
+	r.perAddr[addr]--
+	if r.perAddr[addr] <= 0 {
+		delete(r.perAddr, addr)
+	}
+}
+
+// fragmentStore keeps the fragments of sent messages, so fragments the
+// receiver missed can be sent again
+type fragmentStore struct {
+	mu  sync.Mutex
+	lru *simplelru.LRU
+}
+
+type sentMessage struct {
+	datagrams [][]byte
+	created   time.Time
+}
+
+func newFragmentStore() *fragmentStore {
+	l, err := simplelru.NewLRU(sentFragmentsSize, nil)
+	if err != nil {
+		panic(err)
+	}
+	return &fragmentStore{lru: l}
+}
+
+func (s *fragmentStore) put(addr net.Addr, messageID uint64, datagrams [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fmt.Sprintf("%s%s%d", addr, MapKeySeparator, messageID)
+	s.lru.Add(key, &sentMessage{datagrams: datagrams, created: time.Now()})
+}
+
+// get returns the requested fragments of a sent message
+func (s *fragmentStore) get(addr net.Addr, messageID uint64, indices []int) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fmt.Sprintf("%s%s%d", addr, MapKeySeparator, messageID)
+	val, ok := s.lru.Get(key)
+	if !ok {
+		return nil
+	}
+	sent := val.(*sentMessage)
+	if time.Since(sent.created) > sentFragmentsTTL {
+		s.lru.Remove(key)
+		return nil
+	}
+	var datagrams [][]byte
+	for _, i := range indices {
+		if i < len(sent.datagrams) {
+			datagrams = append(datagrams, sent.datagrams[i])
+		}
+	}
+	return datagrams
+}
+
+func isNetTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func testMessage(n int) []byte {
+	msg := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(msg)
+	return msg
+}
+
+func parseFragments(t *testing.T, datagrams [][]byte) []*fragment {
+	fragments := make([]*fragment, len(datagrams))
+	for i, buf := range datagrams {
+		if !isFragment(buf) {
+			t.Fatalf("datagram %d is not a fragment", i)
+		}
+		f, err := parseFragment(buf)
+		if err != nil {
+			t.Fatalf("datagram %d: %v", i, err)
+		}
+		fragments[i] = f
+	}
+	return fragments
+}
+
+func TestSplitMessage(t *testing.T) {
+	small := testMessage(MaxBufferSize)
+	datagrams, _, err := splitMessage(small)
+	if err != nil || len(datagrams) != 1 || !bytes.Equal(datagrams[0], small) {
+		t.Fatalf("message of %d bytes split into %d datagrams, %v", len(small), len(datagrams), err)
+	}
+
+	if _, _, err := splitMessage(testMessage(MaxMessageSize + 1)); err == nil {
+		t.Fatal("message above the maximum size split")
+	}
+
+	for _, n := range []int{MaxBufferSize + 1, 3 * FragmentPayloadSize, MaxMessageSize} {
+		msg := testMessage(n)
+		datagrams, messageID, err := splitMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (n + FragmentPayloadSize - 1) / FragmentPayloadSize; len(datagrams) != want {
+			t.Fatalf("message of %d bytes split into %d datagrams, want %d", n, len(datagrams), want)
+		}
+		r := newReassembler()
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		var got []byte
+		for i, f := range parseFragments(t, datagrams) {
+			if len(datagrams[i]) > MaxBufferSize {
+				t.Fatalf("fragment %d is %d bytes", i, len(datagrams[i]))
+			}
+			if f.messageID != messageID {
+				t.Fatalf("fragment %d of message %d has ID %d", i, messageID, f.messageID)
+			}
+			got = r.add(addr, f)
+			if got != nil && i != len(datagrams)-1 {
+				t.Fatalf("message complete after fragment %d of %d", i, len(datagrams))
+			}
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("message of %d bytes reassembled into %d bytes", n, len(got))
+		}
+		if r.pending() {
+			t.Fatal("complete message is still pending")
+		}
+	}
+}
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	msg := testMessage(5 * FragmentPayloadSize)
+	datagrams, _, err := splitMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments := parseFragments(t, datagrams)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	r := newReassembler()
+	var got []byte
+	// in reverse order, with duplicates of fragments already received
+	for _, i := range []int{4, 3, 3, 2, 4, 1, 2, 0} {
+		if got != nil {
+			t.Fatalf("message complete before fragment %d", i)
+		}
+		got = r.add(addr, fragments[i])
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("message of %d bytes reassembled into %d bytes", len(msg), len(got))
+	}
+
+	// a fragment disagreeing on the total of the message is ignored
+	r = newReassembler()
+	r.add(addr, fragments[0])
+	bad := *fragments[1]
+	bad.total++
+	if r.add(addr, &bad) != nil {
+		t.Fatal("inconsistent fragment completed the message")
+	}
+	if missing := r.partial[partialKey(addr, fragments[0].messageID)].missing(); len(missing) != 4 {
+		t.Fatalf("missing fragments %v after inconsistent fragment", missing)
+	}
+}
+
+// partialKey is the key of a message in the reassembler
+func partialKey(addr net.Addr, messageID uint64) string {
+	return fmt.Sprintf("%s%s%d", addr, MapKeySeparator, messageID)
+}
+
+func TestParseFragmentTotal(t *testing.T) {
+	datagram := func(index, total uint16) []byte {
+		buf := make([]byte, fragmentHeaderSize+1)
+		binary.BigEndian.PutUint32(buf, FragmentMagic)
+		buf[4] = fragmentData
+		binary.BigEndian.PutUint64(buf[5:], 1)
+		binary.BigEndian.PutUint16(buf[13:], index)
+		binary.BigEndian.PutUint16(buf[15:], total)
+		return buf
+	}
+
+	if _, err := parseFragment(datagram(MaxFragments-1, MaxFragments)); err != nil {
+		t.Fatalf("last of %d fragments: %v", MaxFragments, err)
+	}
+	for _, tc := range []struct{ index, total uint16 }{
+		{0, 0},
+		{0, MaxFragments + 1},
+		{0, 0xffff},
+		{2, 2},
+	} {
+		if _, err := parseFragment(datagram(tc.index, tc.total)); err == nil {
+			t.Errorf("fragment %d of %d parsed", tc.index, tc.total)
+		}
+	}
+	if _, err := parseFragment(datagram(0, 1)[:fragmentHeaderSize-1]); err == nil {
+		t.Error("truncated fragment parsed")
+	}
+}
+
+// partialFragment is the first of two fragments of a message
+func partialFragment(messageID uint64) *fragment {
+	return &fragment{kind: fragmentData, messageID: messageID, index: 0, total: 2, payload: []byte{1}}
+}
+
+func TestReassemblerPerAddrLimit(t *testing.T) {
+	r := newReassembler()
+	a := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	b := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	r.add(b, partialFragment(0))
+	for i := 1; i <= maxPartialPerAddr+2; i++ {
+		r.add(a, partialFragment(uint64(i)))
+		time.Sleep(time.Millisecond)
+	}
+	if n := r.perAddr[a.String()]; n != maxPartialPerAddr {
+		t.Fatalf("%d incomplete messages from one sender, want %d", n, maxPartialPerAddr)
+	}
+	if n := r.perAddr[b.String()]; n != 1 {
+		t.Fatalf("message of another sender dropped")
+	}
+	// the oldest messages of the sender were dropped
+	for i := 1; i <= 2; i++ {
+		if _, ok := r.partial[partialKey(a, uint64(i))]; ok {
+			t.Errorf("oldest message %d kept", i)
+		}
+	}
+	if len(r.partial) != maxPartialPerAddr+1 {
+		t.Fatalf("%d incomplete messages kept", len(r.partial))
+	}
+}
+
+func TestReassemblerTotalLimit(t *testing.T) {
+	defer func(n int) { maxPartialMessages = n }(maxPartialMessages)
+	maxPartialMessages = 4
+
+	r := newReassembler()
+	for i := 0; i < maxPartialMessages+2; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: i + 1}
+		r.add(addr, partialFragment(1))
+		time.Sleep(time.Millisecond)
+	}
+	if len(r.partial) != maxPartialMessages || len(r.perAddr) != maxPartialMessages {
+		t.Fatalf("%d incomplete messages from %d senders kept, want %d", len(r.partial), len(r.perAddr), maxPartialMessages)
+	}
+	for port := 1; port <= 2; port++ {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		if _, ok := r.perAddr[addr.String()]; ok {
+			t.Errorf("oldest message from %s kept", addr)
+		}
+	}
+}
+
+func TestFragmentNack(t *testing.T) {
+	defer func(d time.Duration) { fragmentNackInterval = d }(fragmentNackInterval)
+	fragmentNackInterval = 0
+
+	msg := testMessage(4 * FragmentPayloadSize)
+	datagrams, messageID, err := splitMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	sent := newFragmentStore()
+	sent.put(addr, messageID, datagrams)
+
+	// fragments 1 and 3 are lost
+	r := newReassembler()
+	fragments := parseFragments(t, datagrams)
+	r.add(addr, fragments[0])
+	r.add(addr, fragments[2])
+
+	stalled := r.stalled()
+	if len(stalled) != 1 {
+		t.Fatalf("%d stalled messages, want 1", len(stalled))
+	}
+	nack, err := parseFragment(nackDatagram(stalled[0].messageID, stalled[0].missing()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nack.kind != fragmentNack || nack.messageID != messageID || len(nack.missing) != 2 {
+		t.Fatalf("nack %+v", nack)
+	}
+
+	var got []byte
+	for _, f := range parseFragments(t, sent.get(addr, nack.messageID, nack.missing)) {
+		got = r.add(addr, f)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("message of %d bytes reassembled into %d bytes after retransmission", len(msg), len(got))
+	}
+
+	// messages sent to others or unknown can't be retransmitted
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+	if d := sent.get(other, messageID, nack.missing); d != nil {
+		t.Fatalf("fragments of another receiver retransmitted")
+	}
+	if d := sent.get(addr, messageID+1, nack.missing); d != nil {
+		t.Fatalf("fragments of an unknown message retransmitted")
+	}
+}
+
+func TestFragmentNackGivesUp(t *testing.T) {
+	defer func(d time.Duration) { fragmentNackInterval = d }(fragmentNackInterval)
+	fragmentNackInterval = 0
+
+	r := newReassembler()
+	r.add(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, partialFragment(1))
+	for i := 0; i < maxFragmentNacks; i++ {
+		if n := len(r.stalled()); n != 1 {
+			t.Fatalf("nack %d: %d stalled messages", i+1, n)
+		}
+	}
+	if n := len(r.stalled()); n != 0 || r.pending() {
+		t.Fatalf("message still pending after %d nacks", maxFragmentNacks)
+	}
+}
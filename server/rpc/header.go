@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// RequestHeaderMagic starts a request carrying a RequestHeader. A plain message
+// starts with the length of its method name, which is never negative, so the
+// high bit tells the two apart.
+const RequestHeaderMagic uint32 = 0x80A70001
+
+// SignedRequestHeaderMagic starts a request carrying a RequestHeader signed
+// with a key, see WriteSignedRequestHeader
+const SignedRequestHeaderMagic uint32 = 0x80A70003
+
+// SignatureSize is the size of the HMAC-SHA256 signature of a signed header
+const SignatureSize = sha256.Size
+
+// RequestHeader identifies a request independently of its content and of the
+// address it was sent from. A retransmitted request keeps the same header, a
+// new request from the same client gets a new RequestID.
+type RequestHeader struct {
+	ClientID  string
+	RequestID int64
+
+	// KeyID names the key a signed request is signed with, it's empty if the
+	// request isn't signed
+	KeyID string
+	// Timestamp is when a signed request was signed, in milliseconds since
+	// the unix epoch
+	Timestamp int64
+	Signature []byte
+
+	// the bytes covered by the signature, the header up to the signature and
+	// the message body
+	signed [][]byte
+}
+
+var errMalformedHeader = NewProtocolExceptionWithType(InvalidDataID, errors.New("malformed request header"))
+
+// ReadRequestHeader splits a raw request into its header and message body.
+// The returned header is nil if the request doesn't start with one. The
+// signature of a signed header isn't verified, see HMACAuthenticator.
+func ReadRequestHeader(req []byte) (*RequestHeader, []byte, error) {
+	if len(req) < 4 {
+		return nil, req, nil
+	}
+	magic := binary.BigEndian.Uint32(req)
+	if magic != RequestHeaderMagic && magic != SignedRequestHeaderMagic {
+		return nil, req, nil
+	}
+	buf := req[4:]
+
+	clientID, buf, ok := readHeaderString(buf)
+	if !ok || len(buf) < 8 {
+		return nil, nil, errMalformedHeader
+	}
+	hdr := &RequestHeader{ClientID: clientID, RequestID: int64(binary.BigEndian.Uint64(buf))}
+	buf = buf[8:]
+	if magic == RequestHeaderMagic {
+		return hdr, buf, nil
+	}
+
+	hdr.KeyID, buf, ok = readHeaderString(buf)
+	if !ok || hdr.KeyID == "" || len(buf) < 8+SignatureSize {
+		return nil, nil, errMalformedHeader
+	}
+	hdr.Timestamp = int64(binary.BigEndian.Uint64(buf))
+	buf = buf[8:]
+	signedHeader := req[:len(req)-len(buf)]
+	hdr.Signature = buf[:SignatureSize]
+	buf = buf[SignatureSize:]
+	hdr.signed = [][]byte{signedHeader, buf}
+	return hdr, buf, nil
+}
+
+// setSignedBody replaces the message body covered by the signature, for a body
+// which was decoded after the header was read
+func (h *RequestHeader) setSignedBody(body []byte) {
+	if h.signed != nil {
+		h.signed[1] = body
+	}
+}
+
+func readHeaderString(buf []byte) (string, []byte, bool) {
+	if len(buf) < 4 {
+		return "", nil, false
+	}
+	size := int32(binary.BigEndian.Uint32(buf))
+	buf = buf[4:]
+	if size < 0 || int(size) > len(buf) {
+		return "", nil, false
+	}
+	return string(buf[:size]), buf[size:], true
+}
+
+// WriteRequestHeader writes the header in front of a request
+func WriteRequestHeader(w io.Writer, hdr *RequestHeader) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, RequestHeaderMagic)
+	writeHeaderString(buf, hdr.ClientID)
+	binary.Write(buf, binary.BigEndian, hdr.RequestID)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteSignedRequestHeader writes the header in front of the request with
+// the given message body, signed with secret. KeyID and Timestamp of the
+// header must be set, its Signature is set to the computed signature.
+func WriteSignedRequestHeader(w io.Writer, hdr *RequestHeader, secret, body []byte) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, SignedRequestHeaderMagic)
+	writeHeaderString(buf, hdr.ClientID)
+	binary.Write(buf, binary.BigEndian, hdr.RequestID)
+	writeHeaderString(buf, hdr.KeyID)
+	binary.Write(buf, binary.BigEndian, hdr.Timestamp)
+	hdr.Signature = sign(secret, buf.Bytes(), body)
+	buf.Write(hdr.Signature)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeHeaderString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func sign(secret []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
@@ -0,0 +1,570 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marshal writes v, a struct or a pointer to one, as a struct to oprot. The
+// fields written are the ones with an rpc tag giving the field ID, the wire
+// type and options:
+//
+//	type Flight struct {
+//		ID       string    `rpc:"1,string"`
+//		Seats    int32     `rpc:"5"`
+//		Departs  time.Time `rpc:"7,i64"`
+//		Arrives  time.Time `rpc:"8,i64,optional"`
+//		Stops    []*Stop   `rpc:"9,list"`
+//	}
+//
+// The wire type is one of bool, byte, i16, i32, i64, float, string, binary,
+// struct or list, the names of the IDL of cmd/rpcgen. It defaults to the type
+// matching the Go type: bool, byte for int8 and uint8, i16, i32 and i64 for
+// the integers of that size and int, float for float32, string, binary for
+// []byte, struct for structs and pointers to structs and list for other
+// slices. Integers of any size can be sent as any integer type as long as
+// their values fit, float64 as float and time.Time as i64 in milliseconds
+// since the unix epoch, the zero time as 0. The elements of a list have the
+// default type of their Go type.
+//
+// An optional field is only written if it isn't zero, other fields are always
+// written. A nil struct pointer can only be written as an optional field, a
+// nil slice is written as an empty list.
+func Marshal(oprot Protocol, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return errors.New("rpc: Marshal of nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("rpc: Marshal of non-struct %s", rv.Type())
+	}
+	codec, err := structCodecOf(rv.Type())
+	if err != nil {
+		return err
+	}
+	return codec.write(oprot, rv)
+}
+
+// Unmarshal reads a struct from iprot into v, a pointer to a struct tagged as
+// described for Marshal. Fields of unknown IDs are skipped, fields missing
+// from the input are left unchanged. A field of another wire type than the one
+// of its tag fails with a ProtocolException.
+func Unmarshal(iprot Protocol, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("rpc: Unmarshal needs a non-nil pointer to a struct, got %T", v)
+	}
+	codec, err := structCodecOf(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return codec.read(iprot, rv.Elem(), MaxSkipDepth)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+var tagTypes = map[string]Type{
+	"bool":   Bool,
+	"byte":   Byte,
+	"i16":    I16,
+	"i32":    I32,
+	"i64":    I64,
+	"float":  Float,
+	"string": String,
+	"binary": String,
+	"struct": Struct,
+	"list":   List,
+}
+
+// structCodec reads and writes the tagged fields of a struct type
+type structCodec struct {
+	fields []*fieldCodec
+	byID   map[int16]*fieldCodec
+}
+
+type fieldCodec struct {
+	index    int
+	id       int16
+	name     string
+	optional bool
+	value    *valueCodec
+}
+
+// valueCodec reads and writes values of a Go type as a wire type
+type valueCodec struct {
+	wire Type
+	typ  reflect.Type
+	// elem is the codec of the elements of a list
+	elem *valueCodec
+	// fields is the codec of a struct, ptr is true for a pointer to it
+	fields *structCodec
+	ptr    bool
+}
+
+var (
+	codecsMu sync.Mutex
+	codecs   = make(map[reflect.Type]*structCodec)
+)
+
+// structCodecOf returns the codec of a struct type, building it on first use
+func structCodecOf(t reflect.Type) (*structCodec, error) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	return buildStructCodec(t)
+}
+
+func buildStructCodec(t reflect.Type) (*structCodec, error) {
+	if c, ok := codecs[t]; ok {
+		return c, nil
+	}
+	// registered before its fields to end the recursion of recursive types
+	c := &structCodec{byID: make(map[int16]*fieldCodec)}
+	codecs[t] = c
+	if err := c.build(t); err != nil {
+		delete(codecs, t)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *structCodec) build(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("rpc")
+		if !ok || tag == "-" {
+			continue
+		}
+		if sf.PkgPath != "" {
+			return fmt.Errorf("rpc: field %s of %s is tagged but not exported", sf.Name, t)
+		}
+		f, err := parseFieldTag(sf, tag)
+		if err != nil {
+			return fmt.Errorf("rpc: field %s of %s: %v", sf.Name, t, err)
+		}
+		if c.byID[f.id] != nil {
+			return fmt.Errorf("rpc: field %s of %s: duplicate field ID %d", sf.Name, t, f.id)
+		}
+		f.index = i
+		c.fields = append(c.fields, f)
+		c.byID[f.id] = f
+	}
+	return nil
+}
+
+// parseFieldTag parses a tag like "1,i32,optional"
+func parseFieldTag(sf reflect.StructField, tag string) (*fieldCodec, error) {
+	parts := strings.Split(tag, ",")
+	id, err := strconv.ParseInt(parts[0], 10, 16)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("invalid field ID %q", parts[0])
+	}
+	f := &fieldCodec{id: int16(id), name: strings.ToLower(sf.Name[:1]) + sf.Name[1:]}
+	wire := ""
+	for _, opt := range parts[1:] {
+		switch {
+		case opt == "optional":
+			f.optional = true
+		case tagTypes[opt] != 0 && wire == "":
+			wire = opt
+		default:
+			return nil, fmt.Errorf("invalid tag option %q", opt)
+		}
+	}
+	if f.value, err = newValueCodec(sf.Type, wire); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newValueCodec returns the codec of t as the wire type of the given tag name,
+// the default one of t if empty
+func newValueCodec(t reflect.Type, wire string) (*valueCodec, error) {
+	if wire == "" {
+		wire = defaultWireType(t)
+		if wire == "" {
+			return nil, fmt.Errorf("no wire type for %s", t)
+		}
+	}
+	v := &valueCodec{wire: tagTypes[wire], typ: t}
+	ok := false
+	switch wire {
+	case "bool":
+		ok = t.Kind() == reflect.Bool
+	case "byte", "i16", "i32":
+		ok = isInteger(t)
+	case "i64":
+		ok = isInteger(t) || t == timeType
+	case "float":
+		ok = t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case "string":
+		ok = t.Kind() == reflect.String || isBytes(t)
+	case "binary":
+		ok = isBytes(t)
+	case "struct":
+		st := t
+		if st.Kind() == reflect.Ptr {
+			st = st.Elem()
+			v.ptr = true
+		}
+		if ok = st.Kind() == reflect.Struct && st != timeType; ok {
+			fields, err := buildStructCodec(st)
+			if err != nil {
+				return nil, err
+			}
+			v.fields = fields
+		}
+	case "list":
+		if ok = t.Kind() == reflect.Slice; ok {
+			elem, err := newValueCodec(t.Elem(), "")
+			if err != nil {
+				return nil, fmt.Errorf("list element: %v", err)
+			}
+			v.elem = elem
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s can't be sent as %s", t, wire)
+	}
+	return v, nil
+}
+
+func defaultWireType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int8, reflect.Uint8:
+		return "byte"
+	case reflect.Int16:
+		return "i16"
+	case reflect.Int32:
+		return "i32"
+	case reflect.Int64, reflect.Int:
+		return "i64"
+	case reflect.Float32:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if isBytes(t) {
+			return "binary"
+		}
+		return "list"
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return "struct"
+		}
+	case reflect.Struct:
+		if t == timeType {
+			return "i64"
+		}
+		return "struct"
+	}
+	return ""
+}
+
+func isInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+func (c *structCodec) write(oprot Protocol, v reflect.Value) error {
+	for _, f := range c.fields {
+		fv := v.Field(f.index)
+		if f.optional && isZero(fv) {
+			continue
+		}
+		if err := oprot.WriteFieldBegin(f.name, f.value.wire, f.id); err != nil {
+			return err
+		}
+		if err := f.value.write(oprot, fv); err != nil {
+			return PrependError(fmt.Sprintf("field %s: ", f.name), err)
+		}
+		if err := oprot.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return oprot.WriteFieldStop()
+}
+
+func (c *valueCodec) write(oprot Protocol, v reflect.Value) error {
+	switch c.wire {
+	case Bool:
+		return oprot.WriteBool(v.Bool())
+	case Byte:
+		n, err := intValue(v, math.MinInt8, math.MaxUint8)
+		if err != nil {
+			return err
+		}
+		return oprot.WriteByte(byte(n))
+	case I16:
+		n, err := intValue(v, math.MinInt16, math.MaxInt16)
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI16(int16(n))
+	case I32:
+		n, err := intValue(v, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI32(int32(n))
+	case I64:
+		if c.typ == timeType {
+			return oprot.WriteI64(timeToMillis(v.Interface().(time.Time)))
+		}
+		n, err := intValue(v, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI64(n)
+	case Float:
+		return oprot.WriteFloat(float32(v.Float()))
+	case String:
+		if v.Kind() == reflect.String {
+			return oprot.WriteString(v.String())
+		}
+		return oprot.WriteBinary(v.Bytes())
+	case Struct:
+		if c.ptr {
+			if v.IsNil() {
+				return errors.New("nil struct pointer")
+			}
+			v = v.Elem()
+		}
+		return c.fields.write(oprot, v)
+	case List:
+		if err := oprot.WriteListBegin(c.elem.wire, v.Len()); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := c.elem.write(oprot, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return oprot.WriteListEnd()
+	}
+	return fmt.Errorf("can't write %s", c.wire)
+}
+
+// intValue returns the integer value of v, which must be within min and max
+func intValue(v reflect.Value, min, max int64) (int64, error) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := v.Uint(); n <= uint64(max) {
+			return int64(n), nil
+		}
+	default:
+		if n := v.Int(); n >= min && n <= max {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("value %v out of range", v)
+}
+
+func timeToMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func millisToTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.String, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+		return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+	}
+	return false
+}
+
+func (c *structCodec) read(iprot Protocol, v reflect.Value, depth int) error {
+	if depth <= 0 {
+		return NewProtocolExceptionWithType(DepthLimitID, errors.New("depth limit exceeded"))
+	}
+	for {
+		_, fieldType, fieldID, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return PrependError(fmt.Sprintf("%s field %d read error: ", v.Type(), fieldID), err)
+		}
+		if fieldType == Stop {
+			break
+		}
+		f := c.byID[fieldID]
+		if f == nil {
+			if err := Skip(iprot, fieldType); err != nil {
+				return err
+			}
+		} else {
+			if fieldType != f.value.wire {
+				return NewProtocolExceptionWithType(InvalidDataID,
+					fmt.Errorf("field %d of %s is %s, expected %s", fieldID, v.Type(), fieldType, f.value.wire))
+			}
+			if err := f.value.read(iprot, v.Field(f.index), depth); err != nil {
+				return PrependError(fmt.Sprintf("failed reading field %d content: ", fieldID), err)
+			}
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads a value into v, which must be settable
+func (c *valueCodec) read(iprot Protocol, v reflect.Value, depth int) error {
+	switch c.wire {
+	case Bool:
+		b, err := iprot.ReadBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case Byte:
+		b, err := iprot.ReadByte()
+		if err != nil {
+			return err
+		}
+		// a byte is signed on the wire unless read into an unsigned integer
+		n := int64(int8(b))
+		if isUnsigned(v) {
+			n = int64(b)
+		}
+		return setInt(v, n)
+	case I16:
+		n, err := iprot.ReadI16()
+		if err != nil {
+			return err
+		}
+		return setInt(v, int64(n))
+	case I32:
+		n, err := iprot.ReadI32()
+		if err != nil {
+			return err
+		}
+		return setInt(v, int64(n))
+	case I64:
+		n, err := iprot.ReadI64()
+		if err != nil {
+			return err
+		}
+		if c.typ == timeType {
+			v.Set(reflect.ValueOf(millisToTime(n)))
+			return nil
+		}
+		return setInt(v, n)
+	case Float:
+		f, err := iprot.ReadFloat()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(f))
+	case String:
+		if v.Kind() == reflect.String {
+			s, err := iprot.ReadString()
+			if err != nil {
+				return err
+			}
+			v.SetString(s)
+			return nil
+		}
+		b, err := iprot.ReadBinary()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(b)
+	case Struct:
+		if c.ptr {
+			p := reflect.New(c.typ.Elem())
+			if err := c.fields.read(iprot, p.Elem(), depth-1); err != nil {
+				return err
+			}
+			v.Set(p)
+			return nil
+		}
+		return c.fields.read(iprot, v, depth-1)
+	case List:
+		elemType, size, err := iprot.ReadListBegin()
+		if err != nil {
+			return err
+		}
+		if size > 0 && elemType != c.elem.wire {
+			return NewProtocolExceptionWithType(InvalidDataID,
+				fmt.Errorf("list of %s, expected %s", elemType, c.elem.wire))
+		}
+		// the size isn't trusted for the allocation, the elements are read
+		// one by one
+		list := reflect.MakeSlice(c.typ, 0, 0)
+		for i := 0; i < size; i++ {
+			elem := reflect.New(c.typ.Elem()).Elem()
+			if err := c.elem.read(iprot, elem, depth-1); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		v.Set(list)
+		return iprot.ReadListEnd()
+	}
+	return nil
+}
+
+func isUnsigned(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// setInt sets the integer v to n, failing if it doesn't fit
+func setInt(v reflect.Value, n int64) error {
+	if isUnsigned(v) {
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return NewProtocolExceptionWithType(InvalidDataID, fmt.Errorf("value %d out of range of %s", n, v.Type()))
+		}
+		v.SetUint(uint64(n))
+		return nil
+	}
+	if v.OverflowInt(n) {
+		return NewProtocolExceptionWithType(InvalidDataID, fmt.Errorf("value %d out of range of %s", n, v.Type()))
+	}
+	v.SetInt(n)
+	return nil
+}
@@ -0,0 +1,259 @@
+package rpc
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testStop struct {
+	Airport string `rpc:"1"`
+	Minutes int16  `rpc:"2,i32"`
+}
+
+type testFlight struct {
+	ID      string      `rpc:"1,string"`
+	Seats   int32       `rpc:"2"`
+	Fare    float32     `rpc:"3"`
+	Departs time.Time   `rpc:"4,i64"`
+	Arrives time.Time   `rpc:"5,i64,optional"`
+	Stops   []*testStop `rpc:"6,list"`
+	Via     testStop    `rpc:"7"`
+	Next    *testFlight `rpc:"8,optional"`
+	Tags    []string    `rpc:"9"`
+	Data    []byte      `rpc:"10"`
+	Class   uint8       `rpc:"11"`
+	Note    string      `rpc:"12,optional"`
+
+	untagged int
+}
+
+func newTestProtocol() *BinaryProtocol {
+	return NewBinaryProtocol(NewMemoryBuffer())
+}
+
+// fieldIDs reads a struct from prot and returns the IDs of its fields
+func fieldIDs(t *testing.T, prot Protocol) []int16 {
+	var ids []int16
+	for {
+		_, fieldType, id, err := prot.ReadFieldBegin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fieldType == Stop {
+			return ids
+		}
+		ids = append(ids, id)
+		if err := Skip(prot, fieldType); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	departs := time.Date(2026, 10, 17, 8, 30, 15, 250*int(time.Millisecond), time.UTC)
+	in := &testFlight{
+		ID:      "SQ1",
+		Seats:   42,
+		Fare:    99.5,
+		Departs: departs,
+		Arrives: departs.Add(7 * time.Hour),
+		Stops:   []*testStop{{Airport: "BKK", Minutes: 45}, {Airport: "HKG", Minutes: -1}},
+		Via:     testStop{Airport: "TPE", Minutes: 90},
+		Next: &testFlight{
+			ID:      "SQ2",
+			Departs: departs.Add(24 * time.Hour),
+			Stops:   []*testStop{},
+			Tags:    []string{},
+			Data:    []byte{},
+		},
+		Tags:  []string{"a", "b"},
+		Data:  []byte{0, 1, 255},
+		Class: 200,
+		Note:  "window",
+	}
+
+	prot := newTestProtocol()
+	if err := Marshal(prot, in); err != nil {
+		t.Fatal(err)
+	}
+	out := &testFlight{}
+	if err := Unmarshal(prot, out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip of\n%+v\ngave\n%+v", in, out)
+	}
+}
+
+func TestMarshalOptional(t *testing.T) {
+	prot := newTestProtocol()
+	if err := Marshal(prot, testFlight{ID: "SQ1"}); err != nil {
+		t.Fatal(err)
+	}
+	ids := fieldIDs(t, prot)
+	want := []int16{1, 2, 3, 4, 6, 7, 9, 10, 11}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("zero struct written with fields %v, want %v", ids, want)
+	}
+
+	// fields left out are unchanged by Unmarshal
+	if err := Marshal(prot, testFlight{ID: "SQ1"}); err != nil {
+		t.Fatal(err)
+	}
+	out := &testFlight{Note: "kept", Next: &testFlight{ID: "kept"}}
+	if err := Unmarshal(prot, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Note != "kept" || out.Next == nil || out.Next.ID != "kept" {
+		t.Fatalf("optional fields overwritten: %+v", out)
+	}
+	if out.Stops == nil || len(out.Stops) != 0 {
+		t.Fatalf("nil list read as %#v, want an empty list", out.Stops)
+	}
+
+	// a nil struct pointer can only be optional
+	type required struct {
+		Stop *testStop `rpc:"1"`
+	}
+	if err := Marshal(newTestProtocol(), &required{}); err == nil {
+		t.Fatal("nil required struct pointer written")
+	}
+}
+
+func TestMarshalTime(t *testing.T) {
+	type times struct {
+		At time.Time `rpc:"1"`
+	}
+	for _, tc := range []struct {
+		at     time.Time
+		millis int64
+	}{
+		{time.Time{}, 0},
+		{time.Unix(0, 0).Add(time.Millisecond), 1},
+		{time.Date(2026, 10, 17, 8, 0, 0, 0, time.FixedZone("SGT", 8*3600)), 1792195200000},
+	} {
+		prot := newTestProtocol()
+		if err := Marshal(prot, &times{At: tc.at}); err != nil {
+			t.Fatal(err)
+		}
+		_, fieldType, _, err := prot.ReadFieldBegin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		millis, err := prot.ReadI64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fieldType != I64 || millis != tc.millis {
+			t.Errorf("%v written as %s %d, want i64 %d", tc.at, fieldType, millis, tc.millis)
+		}
+
+		prot = newTestProtocol()
+		Marshal(prot, &times{At: tc.at})
+		out := &times{At: time.Now()}
+		if err := Unmarshal(prot, out); err != nil {
+			t.Fatal(err)
+		}
+		if !out.At.Equal(tc.at) || out.At.IsZero() != tc.at.IsZero() {
+			t.Errorf("%v read back as %v", tc.at, out.At)
+		}
+	}
+}
+
+func TestMarshalIntegerOverflow(t *testing.T) {
+	type wide struct {
+		N int64 `rpc:"1,i32"`
+	}
+	type narrow struct {
+		N int16 `rpc:"1,i32"`
+	}
+	type unsigned struct {
+		N uint16 `rpc:"1,i32"`
+	}
+
+	for _, n := range []int64{math.MaxInt32 + 1, math.MinInt32 - 1} {
+		if err := Marshal(newTestProtocol(), &wide{N: n}); err == nil {
+			t.Errorf("%d written as i32", n)
+		}
+	}
+
+	for _, tc := range []struct {
+		n   int64
+		out interface{}
+	}{
+		{math.MaxInt16 + 1, &narrow{}},
+		{math.MinInt16 - 1, &narrow{}},
+		{-1, &unsigned{}},
+		{math.MaxUint16 + 1, &unsigned{}},
+	} {
+		prot := newTestProtocol()
+		if err := Marshal(prot, &wide{N: tc.n}); err != nil {
+			t.Fatal(err)
+		}
+		err := Unmarshal(prot, tc.out)
+		if _, ok := err.(ProtocolException); !ok {
+			t.Errorf("%d read into %T: %v", tc.n, tc.out, err)
+		}
+	}
+
+	// values in range convert between integer sizes
+	prot := newTestProtocol()
+	if err := Marshal(prot, &wide{N: math.MaxUint16}); err != nil {
+		t.Fatal(err)
+	}
+	out := &unsigned{}
+	if err := Unmarshal(prot, out); err != nil || out.N != math.MaxUint16 {
+		t.Errorf("%d read as %d, %v", math.MaxUint16, out.N, err)
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	// an older version of testFlight, the fields it doesn't know are between
+	// and after the known ones and of all kinds
+	type flightV1 struct {
+		ID    string `rpc:"1"`
+		Seats int32  `rpc:"2"`
+		Class uint8  `rpc:"11"`
+	}
+	prot := newTestProtocol()
+	err := Marshal(prot, &testFlight{
+		ID:      "SQ1",
+		Seats:   7,
+		Departs: time.Now(),
+		Stops:   []*testStop{{Airport: "BKK"}},
+		Next:    &testFlight{ID: "SQ2", Tags: []string{"x"}},
+		Data:    []byte{1, 2},
+		Class:   3,
+		Note:    "window",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &flightV1{}
+	if err := Unmarshal(prot, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != "SQ1" || out.Seats != 7 || out.Class != 3 {
+		t.Fatalf("read %+v", out)
+	}
+	if n := prot.Transport().(*MemoryBuffer).Len(); n != 0 {
+		t.Fatalf("%d bytes left unread", n)
+	}
+}
+
+func TestUnmarshalWireTypeMismatch(t *testing.T) {
+	// field 3 of testFlight is a float
+	type flight struct {
+		Seats int32 `rpc:"3"`
+	}
+	prot := newTestProtocol()
+	if err := Marshal(prot, &testFlight{Fare: 1}); err != nil {
+		t.Fatal(err)
+	}
+	err := Unmarshal(prot, &flight{})
+	if _, ok := err.(ProtocolException); !ok {
+		t.Fatalf("float field read into i32: %v", err)
+	}
+}
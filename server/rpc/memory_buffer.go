@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"bytes"
+	"net"
+)
+
+// MemoryBuffer is a Transport reading from and writing to an in-memory buffer,
+// e.g. to encode and decode messages without a network connection
+type MemoryBuffer struct {
+	*bytes.Buffer
+}
+
+// NewMemoryBuffer creates an empty memory buffer
+func NewMemoryBuffer() *MemoryBuffer {
+	return &MemoryBuffer{Buffer: new(bytes.Buffer)}
+}
+
+// NewMemoryBufferWithData creates a memory buffer to read data from
+func NewMemoryBufferWithData(data []byte) *MemoryBuffer {
+	return &MemoryBuffer{Buffer: bytes.NewBuffer(data)}
+}
+
+func (p *MemoryBuffer) IsOpen() bool {
+	return true
+}
+
+func (p *MemoryBuffer) Close() error {
+	p.Buffer.Reset()
+	return nil
+}
+
+// Flush does nothing, written data stays in the buffer
+func (p *MemoryBuffer) Flush() error {
+	return nil
+}
+
+func (p *MemoryBuffer) Address() net.Addr {
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 )
@@ -26,6 +27,7 @@ type Protocol interface {
 	WriteByte(value byte) error
 	WriteI16(value int16) error
 	WriteI32(value int32) error
+	WriteI64(value int64) error
 	WriteFloat(value float32) error
 	WriteString(value string) error
 	WriteBinary(value []byte) error
@@ -40,6 +42,7 @@ type Protocol interface {
 	ReadByte() (value byte, err error)
 	ReadI16() (value int16, err error)
 	ReadI32() (value int32, err error)
+	ReadI64() (value int64, err error)
 	ReadFloat() (value float32, err error)
 	ReadString() (value string, err error)
 	ReadBinary() (value []byte, err error)
@@ -51,19 +54,29 @@ type Protocol interface {
 // Concrete binary protocol
 
 type BinaryProtocol struct {
-	// trans         TRichTransport
-	trans *UDPSocket
-	// reader        io.Reader
-	// writer        io.Writer
-	// strictRead  bool
-	// strictWrite bool
+	trans Transport
+
+	// byte level fast paths, nil if the transport doesn't provide them
+	byteReader   io.ByteReader
+	byteWriter   io.ByteWriter
+	stringWriter io.StringWriter
+
 	buffer [64]byte
 }
 
 type BinaryProtocolFactory struct{}
 
 func NewBinaryProtocol(trans Transport) *BinaryProtocol {
-	p := &BinaryProtocol{trans: trans.(*UDPSocket)}
+	p := &BinaryProtocol{trans: trans}
+	if r, ok := trans.(io.ByteReader); ok {
+		p.byteReader = r
+	}
+	if w, ok := trans.(io.ByteWriter); ok {
+		p.byteWriter = w
+	}
+	if w, ok := trans.(io.StringWriter); ok {
+		p.stringWriter = w
+	}
 	return p
 }
 
@@ -85,7 +98,12 @@ func (p *BinaryProtocol) WriteBool(value bool) error {
 }
 
 func (p *BinaryProtocol) WriteByte(value byte) error {
-	e := p.trans.WriteByte(value)
+	if p.byteWriter != nil {
+		return NewProtocolException(p.byteWriter.WriteByte(value))
+	}
+	v := p.buffer[0:1]
+	v[0] = value
+	_, e := p.trans.Write(v)
 	return NewProtocolException(e)
 }
 
@@ -103,6 +121,13 @@ func (p *BinaryProtocol) WriteI32(value int32) error {
 	return NewProtocolException(e)
 }
 
+func (p *BinaryProtocol) WriteI64(value int64) error {
+	v := p.buffer[0:8]
+	binary.BigEndian.PutUint64(v, uint64(value))
+	_, e := p.trans.Write(v)
+	return NewProtocolException(e)
+}
+
 func (p *BinaryProtocol) WriteFloat(value float32) error {
 	return p.WriteI32(int32(math.Float32bits(value)))
 }
@@ -112,7 +137,11 @@ func (p *BinaryProtocol) WriteString(value string) error {
 	if e != nil {
 		return NewProtocolException(e)
 	}
-	_, e = p.trans.WriteString(value)
+	if p.stringWriter != nil {
+		_, e = p.stringWriter.WriteString(value)
+	} else {
+		_, e = p.trans.Write([]byte(value))
+	}
 	return NewProtocolException(e)
 }
 
@@ -152,8 +181,7 @@ func (p *BinaryProtocol) WriteFieldEnd() error {
 }
 
 func (p *BinaryProtocol) WriteFieldStop() error {
-	e := p.trans.WriteByte(byte(Stop))
-	return e
+	return p.WriteByte(byte(Stop))
 }
 
 func (p *BinaryProtocol) WriteMessageBegin(name string, typeID MessageType, seqID int32) error {
@@ -264,8 +292,13 @@ func (p *BinaryProtocol) ReadBool() (bool, error) {
 }
 
 func (p *BinaryProtocol) ReadByte() (byte, error) {
-	v, err := p.trans.ReadByte()
-	return v, err
+	if p.byteReader != nil {
+		v, err := p.byteReader.ReadByte()
+		return v, NewProtocolException(err)
+	}
+	buf := p.buffer[0:1]
+	err := p.readAll(buf)
+	return buf[0], err
 }
 
 func (p *BinaryProtocol) ReadI16() (value int16, err error) {
@@ -282,6 +315,13 @@ func (p *BinaryProtocol) ReadI32() (value int32, err error) {
 	return value, err
 }
 
+func (p *BinaryProtocol) ReadI64() (value int64, err error) {
+	buf := p.buffer[0:8]
+	err = p.readAll(buf)
+	value = int64(binary.BigEndian.Uint64(buf))
+	return value, err
+}
+
 func (p *BinaryProtocol) ReadFloat() (value float32, err error) {
 	buf := p.buffer[0:4]
 	err = p.readAll(buf)
@@ -365,3 +405,67 @@ func (p *BinaryProtocol) Flush() (err error) {
 func (p *BinaryProtocol) Transport() Transport {
 	return p.trans
 }
+
+// MaxSkipDepth bounds the nesting of structs and lists Skip descends into
+const MaxSkipDepth = 64
+
+// Skip reads and discards a value of the given type, e.g. a field the reader
+// doesn't know, leaving the protocol at whatever follows the value
+func Skip(iprot Protocol, fieldType Type) error {
+	return skip(iprot, fieldType, MaxSkipDepth)
+}
+
+func skip(iprot Protocol, fieldType Type, depth int) error {
+	if depth <= 0 {
+		return NewProtocolExceptionWithType(DepthLimitID, errors.New("depth limit exceeded"))
+	}
+
+	var err error
+	switch fieldType {
+	case Void:
+	case Bool:
+		_, err = iprot.ReadBool()
+	case Byte:
+		_, err = iprot.ReadByte()
+	case Float:
+		_, err = iprot.ReadFloat()
+	case I16:
+		_, err = iprot.ReadI16()
+	case I32:
+		_, err = iprot.ReadI32()
+	case I64:
+		_, err = iprot.ReadI64()
+	case String:
+		_, err = iprot.ReadString()
+	case Struct:
+		for {
+			_, typeID, _, err := iprot.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if typeID == Stop {
+				break
+			}
+			if err := skip(iprot, typeID, depth-1); err != nil {
+				return err
+			}
+			if err := iprot.ReadFieldEnd(); err != nil {
+				return err
+			}
+		}
+	case List:
+		elemType, size, err := iprot.ReadListBegin()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := skip(iprot, elemType, depth-1); err != nil {
+				return err
+			}
+		}
+		return iprot.ReadListEnd()
+	default:
+		return NewProtocolExceptionWithType(InvalidDataID, fmt.Errorf("unknown type %d", fieldType))
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package rpc
+
+import "fmt"
+
+// CodedError is an error replied to with an application exception of its own
+// type ID, ApplicationException is one
+type CodedError interface {
+	error
+	TypeID() int32
+}
+
+// NewMethodException returns the exception answering a call of method which
+// failed with err. A CodedError is passed on with its type ID, anything else
+// is an internal error.
+func NewMethodException(method string, err error) ApplicationException {
+	if e, ok := err.(CodedError); ok {
+		return NewApplicationException(e.TypeID(), e.Error())
+	}
+	return NewApplicationException(InternalErrorID, "internal server error processing "+method+": "+err.Error())
+}
+
+// NewArgumentsException returns the ProtocolErrorID exception answering a call
+// of method whose arguments failed to decode with err
+func NewArgumentsException(method string, err error) ApplicationException {
+	return NewApplicationException(ProtocolErrorID, fmt.Sprintf("failed to decode arguments of %s: %v", method, err))
+}
+
+// WriteException answers the call of method with an exception
+func WriteException(oprot Protocol, method string, seqID int32, exc ApplicationException) error {
+	if err := oprot.WriteMessageBegin(method, Exception, seqID); err != nil {
+		return err
+	}
+	if err := exc.Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+// ReplyException answers the call of method with an exception, returning the
+// results of ProcessorFunction.Process: the call was processed and failed with
+// exc, unless the exception couldn't be written
+func ReplyException(oprot Protocol, method string, seqID int32, exc ApplicationException) (bool, error) {
+	if err := WriteException(oprot, method, seqID, exc); err != nil {
+		return false, err
+	}
+	return true, exc
+}
+
+// RemoteAddress returns the address of the peer of the transport of prot, the
+// client of a server protocol, or an empty string if unknown
+func RemoteAddress(prot Protocol) string {
+	if addr := prot.Transport().Address(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
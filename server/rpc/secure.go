@@ -0,0 +1,377 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecureMessageMagic starts a message body encrypted by a secure transport. A
+// plain message starts with the length of its method name, which is never
+// negative, so the high bit tells the two apart.
+const SecureMessageMagic uint32 = 0x80A70004
+
+// DefaultReplayWindow is how far the timestamp of an encrypted message may be
+// off the receiver clock
+const DefaultReplayWindow = 2 * time.Minute
+
+const (
+	// magic, message type and timestamp, authenticated but not encrypted
+	secureHeaderSize = 4 + 1 + 8
+	secureNonceSize  = 12
+)
+
+var (
+	errInvalidMessage  = NewTransportException(UnknownTransportExceptionID, "message failed authentication")
+	errReplayedMessage = NewTransportException(UnknownTransportExceptionID, "replayed message")
+	errStaleMessage    = NewTransportException(UnknownTransportExceptionID, "message timestamp out of replay window")
+	errMessageType     = NewTransportException(UnknownTransportExceptionID, "unexpected type of encrypted message")
+)
+
+// LoadSecureKey reads the hex encoded pre-shared key of a secure transport
+// from a file, it must be 16, 24 or 32 bytes long
+func LoadSecureKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: key is not hex encoded", path)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+// secureCodec seals and opens message bodies with AES-GCM. A sealed body is
+// the magic, the type of the sealed message, the time it was sealed in
+// milliseconds since the unix epoch, a random nonce and the ciphertext. All of
+// it is authenticated, so a tampered body or one sealed with another key is
+// rejected, and opened bodies are checked against the replay window.
+type secureCodec struct {
+	aead   cipher.AEAD
+	replay *replayWindow
+}
+
+func newSecureCodec(key []byte, replayWindow time.Duration) (*secureCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secureCodec{aead: aead, replay: newReplayWindow(replayWindow)}, nil
+}
+
+func (c *secureCodec) seal(typeID MessageType, body []byte) ([]byte, error) {
+	sealed := make([]byte, secureHeaderSize+secureNonceSize, secureHeaderSize+secureNonceSize+len(body)+c.aead.Overhead())
+	binary.BigEndian.PutUint32(sealed, SecureMessageMagic)
+	sealed[4] = byte(typeID)
+	binary.BigEndian.PutUint64(sealed[5:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	nonce := sealed[secureHeaderSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(sealed, nonce, body, sealed[:secureHeaderSize]), nil
+}
+
+// open authenticates and decrypts a sealed body, returning the type and body
+// of the sealed message
+func (c *secureCodec) open(sealed []byte) (MessageType, []byte, error) {
+	if !isSealed(sealed) || len(sealed) < secureHeaderSize+secureNonceSize+c.aead.Overhead() {
+		return 0, nil, errInvalidMessage
+	}
+	header := sealed[:secureHeaderSize]
+	nonce := sealed[secureHeaderSize : secureHeaderSize+secureNonceSize]
+	body, err := c.aead.Open(nil, nonce, sealed[secureHeaderSize+secureNonceSize:], header)
+	if err != nil {
+		return 0, nil, errInvalidMessage
+	}
+	sealedAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[5:]))*int64(time.Millisecond))
+	if err := c.replay.check(sealedAt, string(nonce)); err != nil {
+		return 0, nil, err
+	}
+	return MessageType(header[4]), body, nil
+}
+
+// isSealed returns true if body was sealed by a secureCodec
+func isSealed(body []byte) bool {
+	return len(body) >= secureHeaderSize && binary.BigEndian.Uint32(body) == SecureMessageMagic
+}
+
+// replayWindow rejects messages sealed too long ago or in the future, and
+// remembers the IDs of the messages within the window, like their nonces, to
+// reject a second delivery of any of them
+type replayWindow struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newReplayWindow(window time.Duration) *replayWindow {
+	return &replayWindow{
+		window: window,
+		seen:   make(map[string]time.Time),
+		pruned: time.Now(),
+	}
+}
+
+func (w *replayWindow) check(sealedAt time.Time, id string) error {
+	now := time.Now()
+	if sealedAt.Before(now.Add(-w.window)) || sealedAt.After(now.Add(w.window)) {
+		return errStaleMessage
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// nonces of messages which fell out of the window aren't needed anymore
+	if now.Sub(w.pruned) > w.window {
+		for n, expires := range w.seen {
+			if now.After(expires) {
+				delete(w.seen, n)
+			}
+		}
+		w.pruned = now
+	}
+
+	if _, ok := w.seen[id]; ok {
+		return errReplayedMessage
+	}
+	w.seen[id] = sealedAt.Add(w.window)
+	return nil
+}
+
+type secureTransportFactory struct {
+	codec *secureCodec
+}
+
+// NewSecureTransportFactory wraps transports to encrypt and authenticate the
+// messages they carry with a pre-shared AES key, rejecting messages which
+// weren't sealed with the key, were tampered with or are replayed. Messages
+// sealed more than replayWindow away from the receiver clock are rejected.
+//
+// A request header stays in the clear so the reply cache can recognize
+// retransmissions, and the signature of a signed request covers the decrypted
+// body. The secure factory must get the transports accepted by the server
+// transport, other factories like the fault injecting one have to wrap it.
+//
+// Clients wrap their ClientTransport, see client.NewWithTransportFactory.
+func NewSecureTransportFactory(key []byte, replayWindow time.Duration) (TransportFactory, error) {
+	codec, err := newSecureCodec(key, replayWindow)
+	if err != nil {
+		return nil, err
+	}
+	return &secureTransportFactory{codec: codec}, nil
+}
+
+func (p *secureTransportFactory) GetTransport(trans Transport) (Transport, error) {
+	return &secureTransport{Transport: trans, codec: p.codec}, nil
+}
+
+// WrapCachedReply seals a cached reply sent through trans. Replies are cached
+// before they are sealed, so every retransmission is sealed anew and isn't
+// rejected by the client as a replay or as stale.
+func (p *secureTransportFactory) WrapCachedReply(trans Transport) Transport {
+	return &secureTransport{Transport: trans, codec: p.codec}
+}
+
+// secureTransport seals the messages written to the wrapped transport and
+// opens the ones read from it. A message read on a server must be a request,
+// a message received with Receive on a client must be a reply.
+type secureTransport struct {
+	Transport
+	codec *secureCodec
+
+	opened   bool
+	readbuf  *bytes.Reader
+	writebuf bytes.Buffer
+
+	// the last request sent by a client, sealed again when resent
+	lastHeader []byte
+	lastBody   []byte
+}
+
+func (p *secureTransport) Read(buf []byte) (int, error) {
+	if !p.opened {
+		p.opened = true
+		body, err := p.openRequest()
+		if err != nil {
+			p.readbuf = bytes.NewReader(nil)
+			return 0, err
+		}
+		p.readbuf = bytes.NewReader(body)
+	}
+	n, err := p.readbuf.Read(buf)
+	return n, NewTransportExceptionFromError(err)
+}
+
+func (p *secureTransport) openRequest() ([]byte, error) {
+	sealed, err := readMessage(p.Transport)
+	if err != nil {
+		return nil, err
+	}
+	typeID, body, err := p.codec.open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if typeID != Call && typeID != Oneway {
+		return nil, errMessageType
+	}
+	// the signature of the request covers its body as sent by the client
+	if h, ok := p.Transport.(headerer); ok {
+		if hdr := h.Header(); hdr != nil {
+			hdr.setSignedBody(body)
+		}
+	}
+	return body, nil
+}
+
+// readMessage reads the rest of the message available from trans
+func readMessage(trans Transport) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	chunk := make([]byte, MaxBufferSize)
+	for {
+		n, err := trans.Read(chunk)
+		buf.Write(chunk[:n])
+		if err != nil {
+			if NewTransportExceptionFromError(err).TypeID() == EndOfFileID {
+				return buf.Bytes(), nil
+			}
+			return nil, err
+		}
+		if n == 0 {
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+func (p *secureTransport) Write(buf []byte) (int, error) {
+	return p.writebuf.Write(buf)
+}
+
+// Flush seals the written message and sends it. A request header written in
+// front of the message is sent in the clear.
+func (p *secureTransport) Flush() error {
+	msg := make([]byte, p.writebuf.Len())
+	copy(msg, p.writebuf.Bytes())
+	p.writebuf.Reset()
+
+	_, body, err := ReadRequestHeader(msg)
+	if err != nil {
+		return err
+	}
+	p.lastHeader = msg[:len(msg)-len(body)]
+	p.lastBody = body
+	p.recordReply(body)
+	return p.send(p.lastHeader, body)
+}
+
+// recordReply saves a reply in the reply cache of the wrapped transport before
+// it's sealed, see WrapCachedReply
+func (p *secureTransport) recordReply(body []byte) {
+	r, ok := p.Transport.(replyRecorder)
+	if !ok {
+		return
+	}
+	if typeID, _ := peekMessageType(body); typeID == Reply || typeID == Exception {
+		r.RecordReply(body)
+	}
+}
+
+func (p *secureTransport) send(header, body []byte) error {
+	sealed, err := p.seal(header, body)
+	if err != nil {
+		return err
+	}
+	if _, err := p.Transport.Write(sealed); err != nil {
+		return err
+	}
+	return p.Transport.Flush()
+}
+
+func (p *secureTransport) seal(header, body []byte) ([]byte, error) {
+	typeID, _ := peekMessageType(body)
+	sealed, err := p.codec.seal(typeID, body)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), header...), sealed...), nil
+}
+
+// Discard seals the written reply and discards it, see UDPSocket.Discard
+func (p *secureTransport) Discard() error {
+	msg := p.writebuf.Bytes()
+	p.writebuf.Reset()
+	d, ok := p.Transport.(discarder)
+	if !ok {
+		return nil
+	}
+	p.recordReply(msg)
+	sealed, err := p.seal(nil, msg)
+	if err != nil {
+		return err
+	}
+	if _, err := p.Transport.Write(sealed); err != nil {
+		return err
+	}
+	return d.Discard()
+}
+
+// Receive waits for the next reply which can be opened, messages failing
+// authentication are dropped
+func (p *secureTransport) Receive(timeout time.Duration) error {
+	trans, ok := p.Transport.(ClientTransport)
+	if !ok {
+		return NewTransportException(UnknownTransportExceptionID, "not a client transport")
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		if !deadline.IsZero() {
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				return NewTransportException(TimedOutID, "timed out waiting for a valid reply")
+			}
+		}
+		if err := trans.Receive(timeout); err != nil {
+			return err
+		}
+		sealed, err := readMessage(trans)
+		if err != nil {
+			return err
+		}
+		typeID, body, err := p.codec.open(sealed)
+		if err != nil || (typeID != Reply && typeID != Exception) {
+			continue
+		}
+		p.opened = true
+		p.readbuf = bytes.NewReader(body)
+		return nil
+	}
+}
+
+// Resend seals the last request again and sends it, a retransmission of the
+// same sealed message would be rejected as a replay
+func (p *secureTransport) Resend() error {
+	if p.lastBody == nil {
+		return NewTransportException(UnknownTransportExceptionID, "nothing to resend")
+	}
+	return p.send(p.lastHeader, p.lastBody)
+}
@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var testSecureKey = []byte("0123456789abcdef")
+
+func newTestSecureFactory(t *testing.T, replayWindow time.Duration) *secureTransportFactory {
+	f, err := NewSecureTransportFactory(testSecureKey, replayWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.(*secureTransportFactory)
+}
+
+// testMessageBody encodes an empty message of method name and type typeID
+func testMessageBody(t *testing.T, name string, typeID MessageType) []byte {
+	prot := newTestProtocol()
+	if err := prot.WriteMessageBegin(name, typeID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := prot.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	return prot.Transport().(*MemoryBuffer).Bytes()
+}
+
+// recordingBuffer is a memory transport saving replies like a server socket
+type recordingBuffer struct {
+	*MemoryBuffer
+	recorded [][]byte
+}
+
+func (b *recordingBuffer) RecordReply(reply []byte) {
+	b.recorded = append(b.recorded, append([]byte(nil), reply...))
+}
+
+func TestSecureCachedReplyIsSealedAgain(t *testing.T) {
+	server := newTestSecureFactory(t, DefaultReplayWindow)
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	reply := testMessageBody(t, "reserve", Reply)
+
+	// the reply is cached before it's sealed
+	out := &recordingBuffer{MemoryBuffer: NewMemoryBuffer()}
+	trans, _ := server.GetTransport(out)
+	trans.Write(reply)
+	if err := trans.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.recorded) != 1 || !bytes.Equal(out.recorded[0], reply) {
+		t.Fatalf("recorded %x, want the plain reply %x", out.recorded, reply)
+	}
+
+	// every retransmission of the cached reply is sealed anew
+	var sent [][]byte
+	for i := 0; i < 2; i++ {
+		buf := NewMemoryBuffer()
+		trans := server.WrapCachedReply(buf)
+		trans.Write(out.recorded[0])
+		if err := trans.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, append([]byte(nil), buf.Bytes()...))
+	}
+	if bytes.Equal(sent[0], sent[1]) {
+		t.Fatal("cached reply sent twice with the same seal")
+	}
+	for i, sealed := range sent {
+		typeID, body, err := client.codec.open(sealed)
+		if err != nil || typeID != Reply || !bytes.Equal(body, reply) {
+			t.Fatalf("retransmission %d opened as %v %x, %v", i, typeID, body, err)
+		}
+	}
+}
+
+// sealRequest seals a call through a client transport of f and returns the
+// bytes sent and the transport
+func sealRequest(t *testing.T, f *secureTransportFactory, body []byte) ([]byte, *MemoryBuffer, Transport) {
+	buf := NewMemoryBuffer()
+	trans, _ := f.GetTransport(buf)
+	trans.Write(body)
+	if err := trans.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), buf.Bytes()...), buf, trans
+}
+
+// openRequest reads a request from sealed through a server transport of f
+func openRequest(f *secureTransportFactory, sealed []byte) ([]byte, error) {
+	trans, _ := f.GetTransport(NewMemoryBufferWithData(sealed))
+	return readMessage(trans)
+}
+
+func TestSecureTransportRoundTrip(t *testing.T) {
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	server := newTestSecureFactory(t, DefaultReplayWindow)
+	body := testMessageBody(t, "reserve", Call)
+
+	sealed, _, _ := sealRequest(t, client, body)
+	if !isSealed(sealed) || bytes.Contains(sealed, []byte("reserve")) {
+		t.Fatalf("request sent in the clear: %x", sealed)
+	}
+	got, err := openRequest(server, sealed)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("request opened as %x, %v", got, err)
+	}
+
+	// a request header is sent in the clear in front of the sealed body
+	req := new(bytes.Buffer)
+	WriteRequestHeader(req, &RequestHeader{ClientID: "client", RequestID: 1})
+	req.Write(body)
+	sealed, _, _ = sealRequest(t, client, req.Bytes())
+	hdr, rest, err := ReadRequestHeader(sealed)
+	if err != nil || hdr == nil || hdr.ClientID != "client" || !isSealed(rest) {
+		t.Fatalf("header of sealed request read as %+v, %v", hdr, err)
+	}
+
+	// a server only accepts requests
+	sealed, _, _ = sealRequest(t, client, testMessageBody(t, "reserve", Reply))
+	if _, err := openRequest(server, sealed); err != errMessageType {
+		t.Fatalf("reply opened as a request: %v", err)
+	}
+}
+
+func TestSecureTransportRejectsInvalidMessages(t *testing.T) {
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	server := newTestSecureFactory(t, DefaultReplayWindow)
+	body := testMessageBody(t, "reserve", Call)
+
+	sealed, _, _ := sealRequest(t, client, body)
+	for i := range sealed {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := openRequest(server, tampered); err == nil {
+			t.Fatalf("request with byte %d tampered with opened", i)
+		}
+	}
+	if _, err := openRequest(server, sealed[:len(sealed)-1]); err != errInvalidMessage {
+		t.Fatalf("truncated request: %v", err)
+	}
+	if _, err := openRequest(server, body); err != errInvalidMessage {
+		t.Fatalf("plain request: %v", err)
+	}
+
+	other, err := NewSecureTransportFactory([]byte("fedcba9876543210"), DefaultReplayWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _, _ = sealRequest(t, other.(*secureTransportFactory), body)
+	if _, err := openRequest(server, sealed); err != errInvalidMessage {
+		t.Fatalf("request sealed with another key: %v", err)
+	}
+}
+
+func TestSecureTransportRejectsReplays(t *testing.T) {
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	server := newTestSecureFactory(t, DefaultReplayWindow)
+	body := testMessageBody(t, "reserve", Call)
+
+	sealed, _, _ := sealRequest(t, client, body)
+	if _, err := openRequest(server, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openRequest(server, sealed); err != errReplayedMessage {
+		t.Fatalf("replayed request: %v", err)
+	}
+}
+
+func TestSecureTransportRejectsStaleMessages(t *testing.T) {
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	server := newTestSecureFactory(t, 20*time.Millisecond)
+
+	sealed, _, _ := sealRequest(t, client, testMessageBody(t, "reserve", Call))
+	time.Sleep(40 * time.Millisecond)
+	if _, err := openRequest(server, sealed); err != errStaleMessage {
+		t.Fatalf("stale request: %v", err)
+	}
+}
+
+func TestSecureTransportResend(t *testing.T) {
+	client := newTestSecureFactory(t, DefaultReplayWindow)
+	server := newTestSecureFactory(t, DefaultReplayWindow)
+	body := testMessageBody(t, "reserve", Call)
+
+	sealed, buf, trans := sealRequest(t, client, body)
+	buf.Reset()
+	if err := trans.(*secureTransport).Resend(); err != nil {
+		t.Fatal(err)
+	}
+	resent := append([]byte(nil), buf.Bytes()...)
+	if bytes.Equal(sealed, resent) {
+		t.Fatal("request resent with the same seal")
+	}
+
+	// both deliveries are accepted, the reply cache tells them apart
+	for i, req := range [][]byte{sealed, resent} {
+		if got, err := openRequest(server, req); err != nil || !bytes.Equal(got, body) {
+			t.Fatalf("delivery %d opened as %x, %v", i, got, err)
+		}
+	}
+}
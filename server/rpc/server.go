@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"log"
 	"runtime/debug"
 	"sync"
@@ -19,6 +20,9 @@ type UdpServer struct {
 	outputProtocolFactory  ProtocolFactory
 	processorFactory       ProcessorFactory
 	serverTransport        ServerTransport
+
+	// authenticates requests if set, see SetAuthenticator
+	authenticator Authenticator
 }
 
 func NewUdpServer(processor Processor,
@@ -71,6 +75,13 @@ func (p *UdpServer) OutputProtocolFactory() ProtocolFactory {
 	return p.outputProtocolFactory
 }
 
+// SetAuthenticator makes the server authenticate requests with a, the result
+// is passed to the processor in the context, see AuthResultFromContext.
+// It must be called before serving.
+func (p *UdpServer) SetAuthenticator(a Authenticator) {
+	p.authenticator = a
+}
+
 func (p *UdpServer) Listen() error {
 	return p.serverTransport.Listen()
 }
@@ -134,7 +145,83 @@ func (p *UdpServer) Stop() error {
 	return nil
 }
 
+// headerer is implemented by transports of requests which may carry a
+// RequestHeader
+type headerer interface {
+	Header() *RequestHeader
+}
+
+// aborter is implemented by transports holding on to a request until it's
+// answered, see UDPSocket.Abort
+type aborter interface {
+	Abort()
+}
+
+// cachedReplier is implemented by transports of duplicate requests which are
+// answered with a cached reply instead of being processed, see
+// UDPSocket.CachedReply
+type cachedReplier interface {
+	CachedReply() []byte
+}
+
+// cachedReplyWrapper is implemented by transport factories whose transports
+// can send a cached reply, e.g. to apply the faults of NewFaultTransportFactory
+// to it or to seal it again
+type cachedReplyWrapper interface {
+	WrapCachedReply(trans Transport) Transport
+}
+
+// replyRecorder is implemented by transports saving replies in a reply cache,
+// see UDPSocket.RecordReply
+type replyRecorder interface {
+	RecordReply(reply []byte)
+}
+
+// signatureVerifier is implemented by authenticators which can check the
+// signature of a request without accepting it, see
+// HMACAuthenticator.VerifySignature
+type signatureVerifier interface {
+	VerifySignature(hdr *RequestHeader) error
+}
+
+// sendCachedReply answers a duplicate request with the reply cached for it
+func (p *UdpServer) sendCachedReply(client Transport, reply []byte) error {
+	trans := client
+	if w, ok := p.outputTransportFactory.(cachedReplyWrapper); ok {
+		trans = w.WrapCachedReply(client)
+	}
+	if err := p.verifyDuplicate(client, trans); err != nil {
+		log.Println("dropping duplicate request from", client.Address(), err)
+		return nil
+	}
+	if _, err := trans.Write(reply); err != nil {
+		return err
+	}
+	return trans.Flush()
+}
+
+// verifyDuplicate checks the signature of a duplicate request before it gets
+// the cached reply of the request it duplicates, reading it from trans so a
+// sealed body is opened first. Unsigned requests pass.
+func (p *UdpServer) verifyDuplicate(client, trans Transport) error {
+	h, ok := client.(headerer)
+	if p.authenticator == nil || !ok || h.Header() == nil || h.Header().KeyID == "" {
+		return nil
+	}
+	v, ok := p.authenticator.(signatureVerifier)
+	if !ok {
+		return errors.New("signature of duplicate request can't be verified")
+	}
+	if _, err := readMessage(trans); err != nil {
+		return err
+	}
+	return v.VerifySignature(h.Header())
+}
+
 func (p *UdpServer) processRequests(client Transport) error {
+	if c, ok := client.(cachedReplier); ok && c.CachedReply() != nil {
+		return p.sendCachedReply(client, c.CachedReply())
+	}
 	processor := p.processorFactory.GetProcessor(client)
 	inputTransport, e := p.inputTransportFactory.GetTransport(client)
 	if e != nil {
@@ -152,14 +239,39 @@ func (p *UdpServer) processRequests(client Transport) error {
 		}
 	}()
 
+	// the request is authenticated once the processor asks, after reading the
+	// message has decrypted the body covered by the signature
+	ctx := context.Background()
+	if p.authenticator != nil {
+		ctx = withAuthentication(ctx, func() *AuthResult {
+			var hdr *RequestHeader
+			if h, ok := client.(headerer); ok {
+				hdr = h.Header()
+			}
+			principal, err := p.authenticator.Authenticate(hdr)
+			if err != nil {
+				log.Println("failed to authenticate request from", client.Address(), err)
+				// the failure isn't the reply of the request the header
+				// claims to be, so it mustn't be cached for it
+				if a, ok := client.(aborter); ok {
+					a.Abort()
+				}
+			}
+			return &AuthResult{Principal: principal, Err: err}
+		})
+	}
+
 	for {
 		if atomic.LoadInt32(&p.closed) != 0 {
 			return nil
 		}
-		ok, err := processor.Process(context.Background(), inputProtocol, outputProtocol)
+		ok, err := processor.Process(ctx, inputProtocol, outputProtocol)
 		if ok {
 			break
 		} else {
+			if a, isAborter := client.(aborter); isAborter {
+				a.Abort()
+			}
 			return err
 		}
 	}
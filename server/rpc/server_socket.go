@@ -5,6 +5,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 )
 
 type ServerUDPSocket struct {
@@ -15,15 +16,26 @@ type ServerUDPSocket struct {
 	mu          sync.RWMutex
 	interrupted bool
 
-	filterDuplicate bool
+	// fragments of sent replies and of requests being reassembled
+	fragments   *fragmentStore
+	reassembler *reassembler
+
+	// replies of processed requests, duplicate requests are not processed
+	// again if set
+	cache *ReplyCache
 }
 
-func NewServerUDPSocket(listenAddr string, filterDuplicate bool) (*ServerUDPSocket, error) {
+func NewServerUDPSocket(listenAddr string, cache *ReplyCache) (*ServerUDPSocket, error) {
 	addr, err := net.ResolveUDPAddr("udp", listenAddr)
 	if err != nil {
 		return nil, err
 	}
-	return &ServerUDPSocket{addr: addr, filterDuplicate: filterDuplicate}, nil
+	return &ServerUDPSocket{
+		addr:        addr,
+		cache:       cache,
+		fragments:   newFragmentStore(),
+		reassembler: newReassembler(),
+	}, nil
 }
 
 func (p *ServerUDPSocket) Listen() error {
@@ -57,27 +69,78 @@ func (p *ServerUDPSocket) Accept() (Transport, error) {
 	}
 
 	for {
+		// wake up early to ask for missing fragments
+		var deadline time.Time
+		if p.reassembler.pending() {
+			deadline = time.Now().Add(fragmentNackInterval)
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
 		buffer := make([]byte, MaxBufferSize)
-		n, addr, err := p.conn.ReadFrom(buffer) // this is blocking
+		n, addr, err := conn.ReadFrom(buffer) // this is blocking
 		if err != nil {
+			if isNetTimeout(err) {
+				for _, m := range p.reassembler.stalled() {
+					conn.WriteTo(nackDatagram(m.messageID, m.missing()), m.addr)
+				}
+				continue
+			}
 			return nil, err
 		}
 		buffer = buffer[:n]
 
-		trans := NewUDPSocketFromConn(p.conn, addr, buffer, p.filterDuplicate)
-		// directly return if result was previously computed
-		if p.filterDuplicate {
-			if result, ok := GetComputedResult(addr, buffer); ok {
-				log.Println("returning previous computed result")
-				trans.Write(result)
-				trans.Flush()
+		if isFragment(buffer) {
+			buffer = p.handleFragment(conn, addr, buffer)
+			if buffer == nil {
 				continue
 			}
 		}
+
+		hdr, body, err := ReadRequestHeader(buffer)
+		if err != nil {
+			log.Println("dropping request from", addr, err)
+			continue
+		}
+		trans := NewUDPSocketFromConn(conn, addr, body)
+		trans.header = hdr
+		trans.fragments = p.fragments
+
+		if p.cache != nil && expectsReply(body) {
+			key, reply, process := filterRequest(p.cache, addr, hdr, buffer)
+			switch {
+			case reply != nil:
+				// answered by the server with the cached reply
+				trans.cachedReply = reply
+			case !process:
+				continue
+			default:
+				trans.cache = p.cache
+				trans.cacheKey = key
+			}
+		}
 		return trans, nil
 	}
 }
 
+// handleFragment stores a request fragment or retransmits the reply fragments
+// a client asks for. It returns the request the fragment completed.
+func (p *ServerUDPSocket) handleFragment(conn *net.UDPConn, addr net.Addr, buf []byte) []byte {
+	f, err := parseFragment(buf)
+	if err != nil {
+		log.Println("dropping fragment from", addr, err)
+		return nil
+	}
+	if f.kind == fragmentNack {
+		for _, datagram := range p.fragments.get(addr, f.messageID, f.missing) {
+			conn.WriteTo(datagram, addr)
+		}
+		return nil
+	}
+	return p.reassembler.add(addr, f)
+}
+
 // Checks whether the socket is listening.
 func (p *ServerUDPSocket) IsListening() bool {
 	return p.conn != nil
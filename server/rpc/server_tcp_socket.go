@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// ServerTCPSocket accepts length prefixed messages over TCP connections. Each
+// connection can carry any number of requests, every request is returned by
+// Accept as its own Transport.
+type ServerTCPSocket struct {
+	listener net.Listener
+	addr     *net.TCPAddr
+
+	// Protects the listener, connections and interrupted value
+	mu          sync.Mutex
+	conns       map[net.Conn]bool
+	interrupted bool
+
+	requests chan Transport
+	done     chan struct{}
+
+	// replies of processed requests, duplicate requests are not processed
+	// again if set
+	cache *ReplyCache
+}
+
+func NewServerTCPSocket(listenAddr string, cache *ReplyCache) (*ServerTCPSocket, error) {
+	addr, err := net.ResolveTCPAddr("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerTCPSocket{
+		addr:     addr,
+		cache:    cache,
+		conns:    make(map[net.Conn]bool),
+		requests: make(chan Transport),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (p *ServerTCPSocket) Listen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener != nil {
+		return nil
+	}
+	listener, err := net.ListenTCP(p.addr.Network(), p.addr)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	go p.acceptConns(listener)
+	return nil
+}
+
+func (p *ServerTCPSocket) acceptConns(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+			default:
+				log.Println("error accepting TCP connection:", err)
+			}
+			return
+		}
+		p.mu.Lock()
+		p.conns[conn] = true
+		p.mu.Unlock()
+		go p.readRequests(conn)
+	}
+}
+
+// readRequests reads the requests sent over conn until it's closed
+func (p *ServerTCPSocket) readRequests(conn net.Conn) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+
+	writeMu := new(sync.Mutex)
+	addr := conn.RemoteAddr()
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+
+		hdr, body, err := ReadRequestHeader(frame)
+		if err != nil {
+			log.Println("dropping request from", addr, err)
+			continue
+		}
+		trans := newTCPSocketFromConn(conn, writeMu, body)
+		trans.header = hdr
+
+		if p.cache != nil && expectsReply(body) {
+			key, reply, process := filterRequest(p.cache, addr, hdr, frame)
+			switch {
+			case reply != nil:
+				// answered by the server with the cached reply
+				trans.cachedReply = reply
+			case !process:
+				continue
+			default:
+				trans.cache = p.cache
+				trans.cacheKey = key
+			}
+		}
+
+		select {
+		case p.requests <- trans:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *ServerTCPSocket) Accept() (Transport, error) {
+	p.mu.Lock()
+	interrupted := p.interrupted
+	listening := p.listener != nil
+	p.mu.Unlock()
+
+	if interrupted {
+		return nil, errors.New("transport was interrupted")
+	}
+	if !listening {
+		return nil, NewTransportException(NotOpenID, "no underlying server socket")
+	}
+
+	select {
+	case trans := <-p.requests:
+		return trans, nil
+	case <-p.done:
+		return nil, errors.New("transport was interrupted")
+	}
+}
+
+func (p *ServerTCPSocket) Addr() net.Addr {
+	if p.listener != nil {
+		return p.listener.Addr()
+	}
+	return p.addr
+}
+
+func (p *ServerTCPSocket) Close() error {
+	var err error
+	p.mu.Lock()
+	if p.listener != nil {
+		err = p.listener.Close()
+		p.listener = nil
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	return err
+}
+
+func (p *ServerTCPSocket) Interrupt() error {
+	p.mu.Lock()
+	if !p.interrupted {
+		p.interrupted = true
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.Close()
+
+	return nil
+}
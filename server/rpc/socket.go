@@ -3,21 +3,62 @@ package rpc
 import (
 	"bytes"
 	"net"
+	"time"
 )
 
 type UDPSocket struct {
 	conn     *net.UDPConn
 	addr     net.Addr
-	reqsaved []byte
+	header   *RequestHeader
 	readbuf  *bytes.Buffer
 	writebuf *bytes.Buffer
+	lastSent [][]byte
 
-	saveResult bool
+	// fragments of sent messages kept to retransmit those the receiver
+	// missed, and fragments of received messages being reassembled
+	fragments   *fragmentStore
+	reassembler *reassembler
+
+	// replies are saved in cache under cacheKey if cache is set
+	cache    *ReplyCache
+	cacheKey string
+	// the reply was saved with RecordReply instead of as sent
+	replyRecorded bool
+	// reply of the processed request this one duplicates, if found in cache
+	cachedReply []byte
 }
 
-func NewUDPSocketFromConn(conn *net.UDPConn, addr net.Addr, buf []byte, saveResult bool) *UDPSocket {
+func NewUDPSocketFromConn(conn *net.UDPConn, addr net.Addr, buf []byte) *UDPSocket {
 	readbuf := bytes.NewBuffer(buf)
-	return &UDPSocket{conn: conn, addr: addr, readbuf: readbuf, reqsaved: buf, saveResult: saveResult}
+	return &UDPSocket{conn: conn, addr: addr, readbuf: readbuf}
+}
+
+// NewUDPClientSocket creates a socket exchanging messages with the server at
+// serverAddr, the socket has to be opened with Open before use
+func NewUDPClientSocket(serverAddr string) (*UDPSocket, error) {
+	addr, err := net.ResolveUDPAddr("udp", serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPSocket{
+		addr:        addr,
+		readbuf:     new(bytes.Buffer),
+		fragments:   newFragmentStore(),
+		reassembler: newReassembler(),
+	}, nil
+}
+
+// Open binds the client socket to a local port
+func (p *UDPSocket) Open() error {
+	if p.IsOpen() {
+		return NewTransportException(AlreadyOpenID, "socket already open")
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return NewTransportExceptionFromError(err)
+	}
+	p.conn = conn
+	return nil
 }
 
 // Retrieve the underlying net.Conn
@@ -46,7 +87,7 @@ func (p *UDPSocket) Close() error {
 	return nil
 }
 
-//Returns the remote address of the socket.
+// Returns the remote address of the socket.
 func (p *UDPSocket) Addr() net.Addr {
 	return p.addr
 }
@@ -73,22 +114,172 @@ func (p *UDPSocket) Write(buf []byte) (int, error) {
 func (p *UDPSocket) Flush() error {
 	buf := p.writebuf.Bytes()
 
-	if p.saveResult {
-		PutComputedResult(p.addr, p.reqsaved, buf)
+	if p.cache != nil && !p.replyRecorded {
+		p.cache.Put(p.cacheKey, buf)
 	}
 
-	_, err := p.conn.WriteTo(buf, p.addr)
-
 	//reset writebuf
 	p.writebuf = nil
 
-	return err
+	return p.send(buf)
+}
+
+// Discard drops the written reply instead of sending it, as if it was lost
+// on the network. The reply is still saved in the reply cache.
+func (p *UDPSocket) Discard() error {
+	if p.writebuf == nil {
+		return nil
+	}
+	if p.cache != nil && !p.replyRecorded {
+		p.cache.Put(p.cacheKey, p.writebuf.Bytes())
+	}
+	p.writebuf = nil
+	return nil
+}
+
+// send writes buf to the remote address, split in fragments if it doesn't fit
+// in a single datagram
+func (p *UDPSocket) send(buf []byte) error {
+	datagrams, messageID, err := splitMessage(buf)
+	if err != nil {
+		return err
+	}
+	if len(datagrams) > 1 && p.fragments != nil {
+		p.fragments.put(p.addr, messageID, datagrams)
+	}
+	p.lastSent = datagrams
+	return p.sendDatagrams(datagrams)
+}
+
+func (p *UDPSocket) sendDatagrams(datagrams [][]byte) error {
+	for _, datagram := range datagrams {
+		if _, err := p.conn.WriteTo(datagram, p.addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resend sends the last flushed message again
+func (p *UDPSocket) Resend() error {
+	if p.lastSent == nil {
+		return NewTransportException(UnknownTransportExceptionID, "nothing to resend")
+	}
+	return p.sendDatagrams(p.lastSent)
+}
+
+// Receive waits for the next datagram from the remote address and makes it
+// available to Read, it fails with TimedOutID if none arrives within timeout.
+// A zero timeout waits forever.
+func (p *UDPSocket) Receive(timeout time.Duration) error {
+	if !p.IsOpen() {
+		return NewTransportException(NotOpenID, "connection not open")
+	}
+	if p.reassembler == nil {
+		p.reassembler = newReassembler()
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		// wake up early to ask for missing fragments
+		readDeadline := deadline
+		if p.reassembler.pending() {
+			nack := time.Now().Add(fragmentNackInterval)
+			if readDeadline.IsZero() || nack.Before(readDeadline) {
+				readDeadline = nack
+			}
+		}
+		if err := p.conn.SetReadDeadline(readDeadline); err != nil {
+			return NewTransportExceptionFromError(err)
+		}
+
+		buffer := make([]byte, MaxBufferSize)
+		n, addr, err := p.conn.ReadFrom(buffer)
+		if err != nil {
+			if isNetTimeout(err) && (deadline.IsZero() || time.Now().Before(deadline)) {
+				p.requestMissingFragments()
+				continue
+			}
+			return NewTransportExceptionFromError(err)
+		}
+		// ignore datagrams from anyone else
+		if addr.String() != p.addr.String() {
+			continue
+		}
+		buffer = buffer[:n]
+
+		if isFragment(buffer) {
+			msg, err := p.handleFragment(addr, buffer)
+			if err != nil || msg == nil {
+				continue
+			}
+			buffer = msg
+		}
+		p.readbuf = bytes.NewBuffer(buffer)
+		return nil
+	}
+}
+
+// handleFragment stores a received fragment or retransmits the fragments the
+// remote address asks for. It returns the message the fragment completed.
+func (p *UDPSocket) handleFragment(addr net.Addr, buf []byte) ([]byte, error) {
+	f, err := parseFragment(buf)
+	if err != nil {
+		return nil, err
+	}
+	if f.kind == fragmentNack {
+		if p.fragments != nil {
+			return nil, p.sendDatagrams(p.fragments.get(addr, f.messageID, f.missing))
+		}
+		return nil, nil
+	}
+	return p.reassembler.add(addr, f), nil
+}
+
+func (p *UDPSocket) requestMissingFragments() {
+	for _, m := range p.reassembler.stalled() {
+		p.conn.WriteTo(nackDatagram(m.messageID, m.missing()), m.addr)
+	}
 }
 
 func (p *UDPSocket) Address() net.Addr {
 	return p.addr
 }
 
+// RecordReply saves reply in the reply cache in place of the bytes sent for
+// it, for transports encoding every send of a reply anew, see
+// secureTransportFactory.WrapCachedReply
+func (p *UDPSocket) RecordReply(reply []byte) {
+	if p.cache != nil {
+		p.cache.Put(p.cacheKey, reply)
+	}
+	p.replyRecorded = true
+}
+
+// Abort releases the request from the reply cache when processing it failed
+// without a reply or the request failed authentication, letting a retry of the
+// request through. Replies sent afterwards aren't cached.
+func (p *UDPSocket) Abort() {
+	if p.cache != nil {
+		p.cache.Abort(p.cacheKey)
+		p.cache = nil
+	}
+}
+
+// Header returns the header the request was sent with, nil if it had none
+func (p *UDPSocket) Header() *RequestHeader {
+	return p.header
+}
+
+// CachedReply returns the cached reply of the processed request this request
+// duplicates, nil if the request has to be processed
+func (p *UDPSocket) CachedReply() []byte {
+	return p.cachedReply
+}
+
 func (p *UDPSocket) Interrupt() error {
 	if !p.IsOpen() {
 		return nil
@@ -97,10 +288,16 @@ func (p *UDPSocket) Interrupt() error {
 }
 
 func (p *UDPSocket) WriteString(s string) (int, error) {
+	if p.writebuf == nil {
+		p.writebuf = new(bytes.Buffer)
+	}
 	return p.writebuf.WriteString(s)
 }
 
 func (p *UDPSocket) WriteByte(c byte) error {
+	if p.writebuf == nil {
+		p.writebuf = new(bytes.Buffer)
+	}
 	return p.writebuf.WriteByte(c)
 }
 
@@ -0,0 +1,300 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxFrameSize is the largest message accepted over TCP
+	MaxFrameSize = 16 * 1024 * 1024
+)
+
+// readFrame reads a message prefixed by its length
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return nil, NewTransportException(UnknownTransportExceptionID, "frame exceeds maximum size")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeFrame writes a message prefixed by its length
+func writeFrame(w io.Writer, buf []byte) error {
+	if len(buf) > MaxFrameSize {
+		return NewTransportException(UnknownTransportExceptionID, "frame exceeds maximum size")
+	}
+	frame := make([]byte, 4+len(buf))
+	binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+	copy(frame[4:], buf)
+	_, err := w.Write(frame)
+	return err
+}
+
+// TCPSocket exchanges length prefixed messages over a TCP connection. On the
+// server each received message gets its own TCPSocket sharing the connection,
+// replies are written back as frames on that connection.
+type TCPSocket struct {
+	conn     net.Conn
+	addr     net.Addr
+	header   *RequestHeader
+	readbuf  *bytes.Buffer
+	writebuf *bytes.Buffer
+	lastSent []byte
+
+	// serializes frames written to conn
+	writeMu *sync.Mutex
+
+	// replies are saved in cache under cacheKey if cache is set
+	cache    *ReplyCache
+	cacheKey string
+	// the reply was saved with RecordReply instead of as sent
+	replyRecorded bool
+	// reply of the processed request this one duplicates, if found in cache
+	cachedReply []byte
+
+	// client side: frames read from conn by readLoop until closed is closed
+	frames  chan []byte
+	readErr error
+	closed  chan struct{}
+}
+
+func newTCPSocketFromConn(conn net.Conn, writeMu *sync.Mutex, buf []byte) *TCPSocket {
+	return &TCPSocket{
+		conn:    conn,
+		addr:    conn.RemoteAddr(),
+		readbuf: bytes.NewBuffer(buf),
+		writeMu: writeMu,
+	}
+}
+
+// NewTCPClientSocket creates a socket exchanging messages with the server at
+// serverAddr, the socket has to be opened with Open before use
+func NewTCPClientSocket(serverAddr string) (*TCPSocket, error) {
+	addr, err := net.ResolveTCPAddr("tcp", serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPSocket{addr: addr, readbuf: new(bytes.Buffer), writeMu: new(sync.Mutex)}, nil
+}
+
+// Open connects the client socket to the server
+func (p *TCPSocket) Open() error {
+	if p.IsOpen() {
+		return NewTransportException(AlreadyOpenID, "socket already open")
+	}
+	conn, err := net.Dial("tcp", p.addr.String())
+	if err != nil {
+		return NewTransportExceptionFromError(err)
+	}
+	p.conn = conn
+	p.frames = make(chan []byte, 16)
+	p.closed = make(chan struct{})
+	go p.readLoop(conn, p.frames, p.closed)
+	return nil
+}
+
+var errTCPSocketClosed = NewTransportException(NotOpenID, "connection closed")
+
+// readLoop reads frames in the background, so a receive timing out never
+// leaves a frame half read. It stops when the connection fails or closed is
+// closed, the reason is left in readErr once frames is closed.
+func (p *TCPSocket) readLoop(conn net.Conn, frames chan<- []byte, closed <-chan struct{}) {
+	defer close(frames)
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			select {
+			case <-closed:
+				p.readErr = errTCPSocketClosed
+			default:
+				p.readErr = err
+			}
+			return
+		}
+		select {
+		case frames <- frame:
+		case <-closed:
+			p.readErr = errTCPSocketClosed
+			return
+		}
+	}
+}
+
+// Retrieve the underlying net.Conn
+func (p *TCPSocket) Conn() net.Conn {
+	return p.conn
+}
+
+// Returns true if the connection is open
+func (p *TCPSocket) IsOpen() bool {
+	return p.conn != nil
+}
+
+// Closes the socket. On the server the connection is shared by all requests
+// sent over it and owned by ServerTCPSocket, closing the socket of a request
+// leaves it open.
+func (p *TCPSocket) Close() error {
+	if p.closed == nil || p.conn == nil {
+		return nil
+	}
+	close(p.closed)
+	err := p.conn.Close()
+	// wait for readLoop to stop, dropping the frames it didn't hand over
+	for range p.frames {
+	}
+	p.conn = nil
+	p.closed = nil
+	return err
+}
+
+func (p *TCPSocket) Read(buf []byte) (int, error) {
+	if !p.IsOpen() {
+		return 0, NewTransportException(NotOpenID, "connection not open")
+	}
+	n, err := p.readbuf.Read(buf)
+	return n, NewTransportExceptionFromError(err)
+}
+
+func (p *TCPSocket) ReadByte() (byte, error) {
+	return p.readbuf.ReadByte()
+}
+
+func (p *TCPSocket) Write(buf []byte) (int, error) {
+	if p.writebuf == nil {
+		p.writebuf = new(bytes.Buffer)
+	}
+	if !p.IsOpen() {
+		return 0, NewTransportException(NotOpenID, "connection not open")
+	}
+	n, err := p.writebuf.Write(buf)
+	return n, NewTransportExceptionFromError(err)
+}
+
+func (p *TCPSocket) WriteString(s string) (int, error) {
+	if p.writebuf == nil {
+		p.writebuf = new(bytes.Buffer)
+	}
+	return p.writebuf.WriteString(s)
+}
+
+func (p *TCPSocket) WriteByte(c byte) error {
+	if p.writebuf == nil {
+		p.writebuf = new(bytes.Buffer)
+	}
+	return p.writebuf.WriteByte(c)
+}
+
+func (p *TCPSocket) Flush() error {
+	if p.writebuf == nil {
+		return nil
+	}
+	buf := p.writebuf.Bytes()
+
+	if p.cache != nil && !p.replyRecorded {
+		p.cache.Put(p.cacheKey, buf)
+	}
+
+	//reset writebuf
+	p.writebuf = nil
+
+	return p.send(buf)
+}
+
+// Discard drops the written reply instead of sending it, see UDPSocket.Discard
+func (p *TCPSocket) Discard() error {
+	if p.writebuf == nil {
+		return nil
+	}
+	if p.cache != nil && !p.replyRecorded {
+		p.cache.Put(p.cacheKey, p.writebuf.Bytes())
+	}
+	p.writebuf = nil
+	return nil
+}
+
+func (p *TCPSocket) send(buf []byte) error {
+	if !p.IsOpen() {
+		return NewTransportException(NotOpenID, "connection not open")
+	}
+	p.lastSent = buf
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return NewTransportExceptionFromError(writeFrame(p.conn, buf))
+}
+
+// Resend sends the last flushed message again
+func (p *TCPSocket) Resend() error {
+	if p.lastSent == nil {
+		return NewTransportException(UnknownTransportExceptionID, "nothing to resend")
+	}
+	return p.send(p.lastSent)
+}
+
+// Receive waits for the next message from the server and makes it available
+// to Read, it fails with TimedOutID if none arrives within timeout. A zero
+// timeout waits forever.
+func (p *TCPSocket) Receive(timeout time.Duration) error {
+	if p.frames == nil {
+		return NewTransportException(NotOpenID, "connection not open")
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case frame, ok := <-p.frames:
+		if !ok {
+			return NewTransportExceptionFromError(p.readErr)
+		}
+		p.readbuf = bytes.NewBuffer(frame)
+		return nil
+	case <-expired:
+		return NewTransportException(TimedOutID, "timed out waiting for reply")
+	}
+}
+
+func (p *TCPSocket) Address() net.Addr {
+	return p.addr
+}
+
+// RecordReply saves reply in the reply cache, see UDPSocket.RecordReply
+func (p *TCPSocket) RecordReply(reply []byte) {
+	if p.cache != nil {
+		p.cache.Put(p.cacheKey, reply)
+	}
+	p.replyRecorded = true
+}
+
+// Abort releases the request from the reply cache, see UDPSocket.Abort
+func (p *TCPSocket) Abort() {
+	if p.cache != nil {
+		p.cache.Abort(p.cacheKey)
+		p.cache = nil
+	}
+}
+
+// Header returns the header the request was sent with, nil if it had none
+func (p *TCPSocket) Header() *RequestHeader {
+	return p.header
+}
+
+// CachedReply returns the cached reply of the request, see UDPSocket.CachedReply
+func (p *TCPSocket) CachedReply() []byte {
+	return p.cachedReply
+}
@@ -3,9 +3,12 @@ package rpc
 import (
 	"io"
 	"net"
+	"time"
 )
 
 const (
+	// MaxBufferSize is the largest datagram sent or received, longer messages
+	// are split in fragments, see fragment.go
 	MaxBufferSize = 1024
 )
 
@@ -19,6 +22,17 @@ type Transport interface {
 	Address() net.Addr
 }
 
+// ClientTransport is a Transport used by clients, which send a request and
+// wait for the messages sent back by the server
+type ClientTransport interface {
+	Transport
+	// Receive waits up to timeout for the next message from the server and
+	// makes it available to Read
+	Receive(timeout time.Duration) error
+	// Resend sends the last flushed request again
+	Resend() error
+}
+
 type ServerTransport interface {
 	Listen() error
 	Accept() (Transport, error)
@@ -14,17 +14,18 @@ const (
 	String Type = 7
 	Struct Type = 8
 	List   Type = 9
+	I64    Type = 10
 )
 
 var typeNames = map[Type]string{
-	Stop:  "STOP",
-	Void:  "VOID",
-	Bool:  "BOOL",
-	Byte:  "BYTE",
-	Float: "FLOAT",
-	I16:   "I16",
-	I32:   "I32",
-	// I64:    "I64",
+	Stop:   "STOP",
+	Void:   "VOID",
+	Bool:   "BOOL",
+	Byte:   "BYTE",
+	Float:  "FLOAT",
+	I16:    "I16",
+	I32:    "I32",
+	I64:    "I64",
 	String: "STRING",
 	Struct: "STRUCT",
 	// Map:    "MAP",
@@ -47,4 +48,6 @@ const (
 	Call      MessageType = 1
 	Reply     MessageType = 2
 	Exception MessageType = 3
+	// Oneway is a call which gets no reply
+	Oneway MessageType = 4
 )